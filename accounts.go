@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/aws/aws-sdk-go/service/health/healthiface"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// LabelAccountName defines the configured name of an account, only set with accounts
+	LabelAccountName = "account_name"
+
+	// maxConcurrentAccounts is the number of accounts that are polled at the same time
+	maxConcurrentAccounts = 10
+)
+
+var (
+	// accountInfoDesc maps the configured accounts to their names
+	accountInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "account_info"),
+		"Info metric with one series per configured account",
+		[]string{LabelAccountID, LabelAccountName},
+		nil,
+	)
+
+	// accountUpDesc is whether the last poll of an account was successful
+	accountUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "account_up"),
+		"Whether the last poll of the AWS Health API of the account was successful",
+		[]string{LabelAccountID, LabelAccountName},
+		nil,
+	)
+)
+
+// accounts fetches the events of several accounts concurrently, each with
+// its own assumed role, see the accounts of --config.file. It is an
+// alternative to the organization mode without a delegated administrator.
+type accounts struct {
+	accounts []*account
+	// names maps account IDs to the configured names
+	names map[string]string
+
+	// mu guards last, the events of the last successful poll by filter and
+	// account ID. They are kept while an account fails.
+	mu   sync.Mutex
+	last map[*health.EventFilter]map[string][]*health.Event
+}
+
+type account struct {
+	id   string
+	name string
+	api  healthiface.HealthAPI
+
+	// mu guards polled and up, whether the last poll succeeded
+	mu     sync.Mutex
+	polled bool
+	up     bool
+}
+
+// newAccounts assumes the role of every account. The credentials are
+// refreshed automatically before they expire.
+func newAccounts(sess client.ConfigProvider, configs []*accountConfig) *accounts {
+	a := &accounts{names: map[string]string{}}
+	for _, c := range configs {
+		creds := stscreds.NewCredentials(sess, c.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if c.ExternalID != "" {
+				p.ExternalID = aws.String(c.ExternalID)
+			}
+			if c.SessionName != "" {
+				p.RoleSessionName = c.SessionName
+			}
+		})
+		api := health.New(sess, &aws.Config{Credentials: creds})
+		instrumentHandlers(&api.Handlers)
+
+		a.accounts = append(a.accounts, &account{id: c.ID, name: c.Name, api: api})
+		a.names[c.ID] = c.Name
+	}
+	return a
+}
+
+// scrape returns the events of all accounts, the accounts map of the
+// snapshot lists the accounts that reported an event. Unlike the
+// organization mode public events are attributed to every account, as
+// every account reports them. The snapshot keeps the last events of the
+// accounts that fail together with the error of every failed account, the
+// poll only fails if all accounts fail.
+func (a *accounts) scrape(ctx context.Context, filter *health.EventFilter) (*snapshot, error) {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		sem    = make(chan struct{}, maxConcurrentAccounts)
+		events = map[string][]*health.Event{}
+		errs   []error
+	)
+	for _, acc := range a.accounts {
+		wg.Add(1)
+		go func(acc *account) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			evs, err := acc.describeEvents(ctx, filter)
+			acc.setUp(err == nil)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("account %s (%s): %v", acc.name, acc.id, err))
+				return
+			}
+			events[acc.id] = evs
+		}(acc)
+	}
+	wg.Wait()
+	if len(errs) == len(a.accounts) && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	a.mu.Lock()
+	if a.last == nil {
+		a.last = map[*health.EventFilter]map[string][]*health.Event{}
+	}
+	last := a.last[filter]
+	for _, acc := range a.accounts {
+		if _, ok := events[acc.id]; !ok && last != nil {
+			events[acc.id] = last[acc.id]
+		}
+	}
+	a.last[filter] = events
+	a.mu.Unlock()
+
+	snap := &snapshot{accounts: map[string][]string{}, accountNames: a.names}
+	// iterate in the configured order to keep the snapshot stable
+	for _, acc := range a.accounts {
+		for _, e := range events[acc.id] {
+			arn := aws.StringValue(e.Arn)
+			if _, ok := snap.accounts[arn]; !ok {
+				snap.events = append(snap.events, e)
+			}
+			snap.accounts[arn] = append(snap.accounts[arn], acc.id)
+		}
+	}
+	for _, ids := range snap.accounts {
+		sort.Strings(ids)
+	}
+	return snap, errors.Join(errs...)
+}
+
+func (acc *account) setUp(up bool) {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	acc.polled, acc.up = true, up
+}
+
+func (acc *account) describeEvents(ctx context.Context, f *health.EventFilter) ([]*health.Event, error) {
+	var events []*health.Event
+	err := acc.api.DescribeEventsPagesWithContext(ctx, &health.DescribeEventsInput{
+		Filter: f,
+	}, func(out *health.DescribeEventsOutput, lastPage bool) bool {
+		scrapePages.Inc()
+		events = append(events, out.Events...)
+		return true
+	})
+	return events, err
+}
+
+// sameAccounts returns whether a and b poll the same accounts, either may be nil
+func sameAccounts(a, b *accounts) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return reflect.DeepEqual(a.names, b.names)
+}
+
+func (a *accounts) Describe(ch chan<- *prometheus.Desc) {
+	ch <- accountInfoDesc
+	ch <- accountUpDesc
+}
+
+func (a *accounts) Collect(ch chan<- prometheus.Metric) {
+	for _, acc := range a.accounts {
+		ch <- prometheus.MustNewConstMetric(accountInfoDesc, prometheus.GaugeValue, 1, acc.id, acc.name)
+
+		acc.mu.Lock()
+		polled, up := acc.polled, acc.up
+		acc.mu.Unlock()
+		if !polled {
+			continue
+		}
+		v := 0.
+		if up {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(accountUpDesc, prometheus.GaugeValue, v, acc.id, acc.name)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAccountsScrape(t *testing.T) {
+	public := &health.Event{Arn: aws.String("arn:event/public"), EventTypeCategory: aws.String("issue"), Region: aws.String("eu-west-1"), Service: aws.String("EC2"), StatusCode: aws.String("open")}
+	specific := &health.Event{Arn: aws.String("arn:event/specific"), EventTypeCategory: aws.String("scheduledChange"), Region: aws.String("eu-west-1"), Service: aws.String("EC2"), StatusCode: aws.String("upcoming")}
+
+	a := &accounts{
+		accounts: []*account{
+			{id: "111111111111", name: "production", api: &mockHealthAPI{events: []*health.Event{public, specific}}},
+			{id: "222222222222", name: "staging", api: &mockHealthAPI{events: []*health.Event{public}}},
+		},
+		names: map[string]string{"111111111111": "production", "222222222222": "staging"},
+	}
+	e := &exporter{jobs: []*job{{filter: &health.EventFilter{}}}, accounts: a}
+
+	snap, err := e.scrape(context.Background(), e.jobs[0].filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.events) != 2 {
+		t.Errorf("Expected 2 deduplicated events, got %d", len(snap.events))
+	}
+	if accounts := snap.accounts["arn:event/public"]; strings.Join(accounts, ",") != "111111111111,222222222222" {
+		t.Errorf("Expected the public event for both accounts, got %v", accounts)
+	}
+
+	gv := prometheus.NewGaugeVec(eventOpts, e.labels())
+	countEvents(gv, snap)
+	if n := collectCount(gv); n != 3 {
+		t.Errorf("Expected 3 series, got %d", n)
+	}
+	if v := metricValue(gv.WithLabelValues("issue", "eu-west-1", "EC2", "open", "222222222222", "staging")); v != 1 {
+		t.Errorf("Invalid staging count - Expected: 1 Got: %v", v)
+	}
+
+	// a failing account keeps its last events and doesn't fail the others
+	a.accounts[0].api = &mockHealthAPI{events: []*health.Event{specific}}
+	a.accounts[1].api = &mockHealthAPI{err: errors.New("AccessDenied")}
+	snap, err = e.scrape(context.Background(), e.jobs[0].filter)
+	if err == nil || !strings.Contains(err.Error(), "staging") {
+		t.Errorf("Expected error of the staging account, got %v", err)
+	}
+	if snap == nil || len(snap.events) != 2 || strings.Join(snap.accounts["arn:event/public"], ",") != "222222222222" {
+		t.Fatalf("Expected the current events of production and the last of staging, got %v", snap)
+	}
+
+	up := map[string]float64{}
+	ch := make(chan prometheus.Metric, 10)
+	a.Collect(ch)
+	close(ch)
+	for m := range ch {
+		pb := &dto.Metric{}
+		m.Write(pb)
+		if strings.Contains(m.Desc().String(), "account_up") {
+			up[pb.GetLabel()[1].GetValue()] = pb.GetGauge().GetValue()
+		}
+	}
+	if up["production"] != 1 || up["staging"] != 0 || len(up) != 2 {
+		t.Errorf("Expected aws_health_account_up by account, got %v", up)
+	}
+
+	a.accounts[0].api = &mockHealthAPI{err: errors.New("AccessDenied")}
+	if snap, err := e.scrape(context.Background(), e.jobs[0].filter); snap != nil || err == nil {
+		t.Errorf("Expected the poll to fail if all accounts fail, got %v", snap)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+// aggregateCount is the number of events of one category, region and
+// service as returned by DescribeEventAggregates for a filter with a single
+// region and service.
+type aggregateCount struct {
+	category   string
+	region     string
+	service    string
+	statusCode string
+	count      float64
+}
+
+// scrapeAggregated lists only the open and upcoming events and counts the
+// closed events with DescribeEventAggregates, see --aws.aggregate. Closed
+// events are usually the vast majority and don't need to be paged through.
+// The aggregate API can only group by category, so it is called per region
+// and service of the filter to keep these labels. Closed events are listed
+// as usual unless the filter names both regions and services.
+func (e *exporter) scrapeAggregated(ctx context.Context, f *health.EventFilter) (*snapshot, error) {
+	pinned := len(f.Regions) > 0 && len(f.Services) > 0
+
+	var listed, aggregated []string
+	for _, code := range statusCodes(f) {
+		if code == health.EventStatusCodeClosed && pinned {
+			aggregated = append(aggregated, code)
+		} else {
+			listed = append(listed, code)
+		}
+	}
+
+	snap := &snapshot{}
+	if len(listed) > 0 {
+		events, err := e.describeEvents(ctx, withStatusCodes(f, listed))
+		if err != nil {
+			return nil, err
+		}
+		snap.events = events
+	}
+
+	for _, code := range aggregated {
+		for _, region := range f.Regions {
+			for _, service := range f.Services {
+				af := withStatusCodes(f, []string{code})
+				af.Regions = []*string{region}
+				af.Services = []*string{service}
+
+				counts, err := e.describeAggregates(ctx, af)
+				if err != nil {
+					return nil, err
+				}
+				snap.aggregates = append(snap.aggregates, counts...)
+			}
+		}
+	}
+
+	return snap, nil
+}
+
+func (e *exporter) describeAggregates(ctx context.Context, f *health.EventFilter) ([]aggregateCount, error) {
+	var counts []aggregateCount
+
+	err := e.api.DescribeEventAggregatesPagesWithContext(ctx, &health.DescribeEventAggregatesInput{
+		AggregateField: aws.String(health.EventAggregateFieldEventTypeCategory),
+		Filter:         f,
+	}, func(out *health.DescribeEventAggregatesOutput, lastPage bool) bool {
+		scrapePages.Inc()
+		for _, a := range out.EventAggregates {
+			counts = append(counts, aggregateCount{
+				category:   aws.StringValue(a.AggregateValue),
+				region:     aws.StringValue(f.Regions[0]),
+				service:    aws.StringValue(f.Services[0]),
+				statusCode: aws.StringValue(f.EventStatusCodes[0]),
+				count:      float64(aws.Int64Value(a.Count)),
+			})
+		}
+		return true
+	})
+
+	return counts, err
+}
+
+// statusCodes returns the status codes of f or all status codes
+func statusCodes(f *health.EventFilter) []string {
+	if len(f.EventStatusCodes) > 0 {
+		return aws.StringValueSlice(f.EventStatusCodes)
+	}
+	return health.EventStatusCode_Values()
+}
+
+// withStatusCodes returns a copy of f limited to the given status codes
+func withStatusCodes(f *health.EventFilter, codes []string) *health.EventFilter {
+	c := *f
+	c.EventStatusCodes = aws.StringSlice(codes)
+	return &c
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScrapeAggregated(t *testing.T) {
+	event := func(category, region, status string) *health.Event {
+		return &health.Event{
+			EventTypeCategory: aws.String(category),
+			Region:            aws.String(region),
+			Service:           aws.String("EC2"),
+			StatusCode:        aws.String(status),
+		}
+	}
+	api := &mockHealthAPI{events: []*health.Event{
+		event("issue", "eu-west-1", "open"),
+		event("issue", "eu-west-1", "closed"),
+		event("issue", "eu-west-1", "closed"),
+		event("scheduledChange", "eu-west-1", "closed"),
+		event("issue", "us-east-1", "closed"),
+	}}
+	e := &exporter{
+		api: api,
+		jobs: []*job{{filter: &health.EventFilter{
+			Regions:  aws.StringSlice([]string{"eu-west-1", "us-east-1"}),
+			Services: aws.StringSlice([]string{"EC2"}),
+		}}},
+		aggregate: true,
+	}
+
+	snap, err := e.scrape(context.Background(), e.jobs[0].filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.events) != 1 {
+		t.Errorf("Expected only the open event to be listed, got %d events", len(snap.events))
+	}
+	if n := api.calls["DescribeEventAggregates"]; n != 2 {
+		t.Errorf("Expected one aggregate call per region and service, got %d", n)
+	}
+
+	gv := prometheus.NewGaugeVec(eventOpts, labels)
+	countEvents(gv, snap)
+	if v := metricValue(gv.WithLabelValues("issue", "eu-west-1", "EC2", "closed")); v != 2 {
+		t.Errorf("Invalid value - Expected: 2 Got: %v", v)
+	}
+	if v := metricValue(gv.WithLabelValues("issue", "us-east-1", "EC2", "closed")); v != 1 {
+		t.Errorf("Invalid value - Expected: 1 Got: %v", v)
+	}
+	if v := metricValue(gv.WithLabelValues("issue", "eu-west-1", "EC2", "open")); v != 1 {
+		t.Errorf("Invalid value - Expected: 1 Got: %v", v)
+	}
+}
+
+func TestScrapeAggregatedUnpinned(t *testing.T) {
+	api := &mockHealthAPI{events: []*health.Event{
+		{EventTypeCategory: aws.String("issue"), Region: aws.String("eu-west-1"), Service: aws.String("EC2"), StatusCode: aws.String("open")},
+		{EventTypeCategory: aws.String("issue"), Region: aws.String("eu-west-1"), Service: aws.String("RDS"), StatusCode: aws.String("closed")},
+	}}
+	e := &exporter{api: api, aggregate: true}
+
+	// without services the closed events would lose their service label
+	snap, err := e.scrape(context.Background(), &health.EventFilter{Regions: aws.StringSlice([]string{"eu-west-1"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.events) != 2 || len(snap.aggregates) != 0 {
+		t.Errorf("Expected all events to be listed, got %d events and %d aggregates", len(snap.events), len(snap.aggregates))
+	}
+	if n := api.calls["DescribeEventAggregates"]; n != 0 {
+		t.Errorf("Expected no aggregate calls, got %d", n)
+	}
+}
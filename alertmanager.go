@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// alertName is the alertname label of the alerts pushed to Alertmanager
+const alertName = "AWSHealthEvent"
+
+// alertmanager pushes one alert per open event to the Alertmanager v2 API.
+// The open events are re-posted after every poll with an endsAt of ttl in
+// the future, so alerts of events that disappear without being closed
+// resolve on their own. Closed events are resolved immediately.
+type alertmanager struct {
+	url     string
+	ttl     time.Duration
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// alert is a postableAlert of the Alertmanager v2 API
+type alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+func newAlertmanager(url string, ttl, timeout time.Duration, retries int) *alertmanager {
+	return &alertmanager{
+		url:     strings.TrimSuffix(url, "/") + "/api/v2/alerts",
+		ttl:     ttl,
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		backoff: time.Second,
+	}
+}
+
+func (a *alertmanager) name() string {
+	return "alertmanager"
+}
+
+func (a *alertmanager) send(ctx context.Context, t *transition) error {
+	return a.post(ctx, []apiEvent{t.Event}, t.Type == transitionClosed)
+}
+
+func (a *alertmanager) sync(ctx context.Context, open []apiEvent) error {
+	if len(open) == 0 {
+		return nil
+	}
+	return a.post(ctx, open, false)
+}
+
+func (a *alertmanager) post(ctx context.Context, events []apiEvent, resolved bool) error {
+	now := time.Now()
+	alerts := make([]*alert, 0, len(events))
+	for _, e := range events {
+		alerts = append(alerts, newAlert(e, now, a.ttl, resolved))
+	}
+
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	_, err = postWithRetry(ctx, a.client, a.url, body, a.retries, a.backoff, nil)
+	return err
+}
+
+// newAlert returns the alert of e. Alertmanager requires startsAt to be
+// before endsAt, upcoming events start at now.
+func newAlert(e apiEvent, now time.Time, ttl time.Duration, resolved bool) *alert {
+	a := &alert{
+		Labels: map[string]string{
+			"alertname":        alertName,
+			LabelService:       e.Service,
+			LabelRegion:        e.Region,
+			LabelCategory:      e.EventTypeCategory,
+			LabelEventTypeCode: e.EventTypeCode,
+			LabelARN:           e.Arn,
+		},
+		Annotations: map[string]string{
+			"console_url": consoleURL(e.Arn),
+		},
+		StartsAt: now,
+		EndsAt:   now.Add(ttl),
+	}
+	if e.Description != "" {
+		a.Annotations["description"] = e.Description
+	}
+	if e.StartTime != nil && e.StartTime.Before(now) {
+		a.StartsAt = *e.StartTime
+	}
+	if resolved {
+		a.EndsAt = now
+		if e.EndTime != nil && e.EndTime.Before(now) && e.EndTime.After(a.StartsAt) {
+			a.EndsAt = *e.EndTime
+		}
+	}
+	return a
+}
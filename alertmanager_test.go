@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewAlert(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	started := now.Add(-time.Hour)
+	ended := now.Add(-time.Minute)
+	upcoming := now.Add(time.Hour)
+
+	e := apiEvent{Arn: testARN, Service: "EC2", Region: "eu-west-1", EventTypeCategory: "issue", EventTypeCode: "AWS_EC2_OPERATIONAL_ISSUE", StartTime: &started, Description: "Increased error rates"}
+	a := newAlert(e, now, 4*time.Minute, false)
+	if a.Labels["alertname"] != alertName || a.Labels[LabelARN] != testARN || a.Labels[LabelEventTypeCode] != "AWS_EC2_OPERATIONAL_ISSUE" {
+		t.Errorf("Unexpected labels %v", a.Labels)
+	}
+	if a.Annotations["description"] != "Increased error rates" || a.Annotations["console_url"] != consoleURL(testARN) {
+		t.Errorf("Unexpected annotations %v", a.Annotations)
+	}
+	if !a.StartsAt.Equal(started) || !a.EndsAt.Equal(now.Add(4*time.Minute)) {
+		t.Errorf("Unexpected firing interval %s - %s", a.StartsAt, a.EndsAt)
+	}
+
+	e.EndTime = &ended
+	if a := newAlert(e, now, 4*time.Minute, true); !a.EndsAt.Equal(ended) {
+		t.Errorf("Expected resolved alert to end at %s, got %s", ended, a.EndsAt)
+	}
+
+	e.StartTime, e.EndTime = &upcoming, nil
+	if a := newAlert(e, now, 4*time.Minute, false); !a.StartsAt.Equal(now) {
+		t.Errorf("Expected upcoming alert to start at %s, got %s", now, a.StartsAt)
+	}
+}
+
+func TestAlertmanagerSync(t *testing.T) {
+	var got []alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	a := newAlertmanager(srv.URL+"/", time.Minute, time.Second, 0)
+	if err := a.sync(context.Background(), []apiEvent{{Arn: "arn:event/1"}, {Arn: "arn:event/2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].Labels[LabelARN] != "arn:event/2" {
+		t.Errorf("Unexpected alerts %v", got)
+	}
+
+	if err := a.send(context.Background(), &transition{Type: transitionClosed, Event: apiEvent{Arn: "arn:event/1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].EndsAt.After(time.Now()) {
+		t.Errorf("Expected a resolved alert, got %v", got)
+	}
+}
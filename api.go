@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+// apiEvent is the JSON representation of an event on /api/v1/events
+type apiEvent struct {
+	Arn               string            `json:"arn"`
+	Service           string            `json:"service"`
+	EventTypeCode     string            `json:"eventTypeCode"`
+	EventTypeCategory string            `json:"eventTypeCategory"`
+	Region            string            `json:"region"`
+	AvailabilityZone  string            `json:"availabilityZone,omitempty"`
+	StartTime         *time.Time        `json:"startTime,omitempty"`
+	EndTime           *time.Time        `json:"endTime,omitempty"`
+	LastUpdatedTime   *time.Time        `json:"lastUpdatedTime,omitempty"`
+	StatusCode        string            `json:"statusCode"`
+	EventScopeCode    string            `json:"eventScopeCode"`
+	Accounts          []string          `json:"accounts,omitempty"`
+	Description       string            `json:"description,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	Entities          []apiEntity       `json:"entities,omitempty"`
+}
+
+// apiEntity is the JSON representation of an affected entity
+type apiEntity struct {
+	EntityArn       string            `json:"entityArn,omitempty"`
+	EntityValue     string            `json:"entityValue"`
+	EntityURL       string            `json:"entityUrl,omitempty"`
+	AwsAccountID    string            `json:"awsAccountId,omitempty"`
+	StatusCode      string            `json:"statusCode"`
+	LastUpdatedTime *time.Time        `json:"lastUpdatedTime,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+}
+
+// apiHistory is the JSON representation of the stored history of an event
+// on /api/v1/history/{arn}
+type apiHistory struct {
+	Arn      string          `json:"arn"`
+	Versions []apiVersion    `json:"versions"`
+	Statuses []*statusChange `json:"statuses"`
+}
+
+// apiVersion is an observed version of an event
+type apiVersion struct {
+	Observed time.Time `json:"observed"`
+	Event    apiEvent  `json:"event"`
+}
+
+// eventsAPI serves the events of the last snapshot as JSON
+type eventsAPI struct {
+	exporter *exporter
+}
+
+// list serves /api/v1/events. The events can be filtered with the service,
+// region, category, status and scope query parameters, each of them may be
+// repeated, and with from and to (RFC 3339) to the events active in between.
+func (a *eventsAPI) list(w http.ResponseWriter, r *http.Request) {
+	snap := a.exporter.snapshot()
+	if snap == nil {
+		writeError(w, http.StatusServiceUnavailable, "no successful poll of the AWS Health API yet")
+		return
+	}
+
+	q := r.URL.Query()
+	from, err := parseTime(q.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := parseTime(q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events := []apiEvent{}
+	for _, e := range snap.events {
+		if !matchesQuery(q["service"], e.Service) ||
+			!matchesQuery(q["region"], e.Region) ||
+			!matchesQuery(q["category"], e.EventTypeCategory) ||
+			!matchesQuery(q["status"], e.StatusCode) ||
+			!matchesQuery(q["scope"], e.EventScopeCode) ||
+			!activeBetween(e, from, to) {
+			continue
+		}
+		events = append(events, a.event(snap, e))
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
+
+// get serves /api/v1/events/{arn} including the description and the
+// affected entities if they are fetched
+func (a *eventsAPI) get(w http.ResponseWriter, r *http.Request) {
+	snap := a.exporter.snapshot()
+	if snap == nil {
+		writeError(w, http.StatusServiceUnavailable, "no successful poll of the AWS Health API yet")
+		return
+	}
+
+	arn := strings.TrimPrefix(r.URL.Path, "/api/v1/events/")
+	e := snap.find(arn)
+	if e == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown event %s", arn))
+		return
+	}
+
+	event := a.event(snap, e)
+	event.Entities = a.entities(arn)
+
+	writeJSON(w, http.StatusOK, event)
+}
+
+// history serves /api/v1/history/{arn}, the versions and status changes of
+// the event recorded with --store.path. Unlike /api/v1/events/{arn} it
+// includes events that are no longer reported by the AWS Health API.
+func (a *eventsAPI) history(w http.ResponseWriter, r *http.Request) {
+	arn := strings.TrimPrefix(r.URL.Path, "/api/v1/history/")
+	stored, err := a.exporter.store.history(arn)
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, "failed to read the store")
+		return
+	}
+	if stored == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown event %s", arn))
+		return
+	}
+
+	h := apiHistory{Arn: arn, Versions: []apiVersion{}, Statuses: stored.Statuses}
+	for _, v := range stored.Versions {
+		h.Versions = append(h.Versions, apiVersion{Observed: v.Observed, Event: newAPIEvent(v.Event)})
+	}
+	writeJSON(w, http.StatusOK, h)
+}
+
+// event returns e with the accounts of snap and the cached details
+func (a *eventsAPI) event(snap *snapshot, e *health.Event) apiEvent {
+	arn := aws.StringValue(e.Arn)
+	event := newAPIEvent(e)
+	event.Accounts = snap.accounts[arn]
+	if a.exporter.details != nil {
+		event.Description, _ = a.exporter.details.description(arn)
+		event.Metadata = a.exporter.details.metadata(arn)
+	}
+	return event
+}
+
+func newAPIEvent(e *health.Event) apiEvent {
+	return apiEvent{
+		Arn:               aws.StringValue(e.Arn),
+		Service:           aws.StringValue(e.Service),
+		EventTypeCode:     aws.StringValue(e.EventTypeCode),
+		EventTypeCategory: aws.StringValue(e.EventTypeCategory),
+		Region:            aws.StringValue(e.Region),
+		AvailabilityZone:  aws.StringValue(e.AvailabilityZone),
+		StartTime:         e.StartTime,
+		EndTime:           e.EndTime,
+		LastUpdatedTime:   e.LastUpdatedTime,
+		StatusCode:        aws.StringValue(e.StatusCode),
+		EventScopeCode:    aws.StringValue(e.EventScopeCode),
+	}
+}
+
+// entities returns the cached affected entities of the event with the given ARN
+func (a *eventsAPI) entities(arn string) []apiEntity {
+	if a.exporter.entities == nil {
+		return nil
+	}
+
+	var entities []apiEntity
+	cached, _ := a.exporter.entities.get(arn)
+	for _, ent := range cached {
+		entities = append(entities, apiEntity{
+			EntityArn:       aws.StringValue(ent.EntityArn),
+			EntityValue:     aws.StringValue(ent.EntityValue),
+			EntityURL:       aws.StringValue(ent.EntityUrl),
+			AwsAccountID:    aws.StringValue(ent.AwsAccountId),
+			StatusCode:      aws.StringValue(ent.StatusCode),
+			LastUpdatedTime: ent.LastUpdatedTime,
+			Tags:            aws.StringValueMap(ent.Tags),
+		})
+	}
+	return entities
+}
+
+// find returns the event with the given ARN or nil
+func (s *snapshot) find(arn string) *health.Event {
+	for _, e := range s.events {
+		if aws.StringValue(e.Arn) == arn {
+			return e
+		}
+	}
+	return nil
+}
+
+// matchesQuery returns true if values is empty or contains v, ignoring case
+func matchesQuery(values []string, v *string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if strings.EqualFold(value, aws.StringValue(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeBetween returns true if e started before to and did not end before
+// from. A zero from or to is unbounded.
+func activeBetween(e *health.Event, from, to time.Time) bool {
+	if !to.IsZero() && e.StartTime != nil && e.StartTime.After(to) {
+		return false
+	}
+	if !from.IsZero() && e.EndTime != nil && e.EndTime.Before(from) {
+		return false
+	}
+	return true
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339", s)
+	}
+	return t, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+const testARN = "arn:aws:health:eu-west-1::event/EC2/AWS_EC2_OPERATIONAL_ISSUE/AWS_EC2_OPERATIONAL_ISSUE_1"
+
+func newTestEventsAPI(t *testing.T) *eventsAPI {
+	api := &mockHealthAPI{
+		events: []*health.Event{
+			&health.Event{
+				Arn:               aws.String(testARN),
+				EventTypeCode:     aws.String("AWS_EC2_OPERATIONAL_ISSUE"),
+				EventTypeCategory: aws.String("issue"),
+				EventScopeCode:    aws.String("PUBLIC"),
+				Region:            aws.String("eu-west-1"),
+				Service:           aws.String("EC2"),
+				StatusCode:        aws.String("open"),
+				StartTime:         aws.Time(time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)),
+			},
+			&health.Event{
+				Arn:               aws.String("arn:event/lambda"),
+				EventTypeCategory: aws.String("issue"),
+				Region:            aws.String("us-east-1"),
+				Service:           aws.String("LAMBDA"),
+				StatusCode:        aws.String("closed"),
+				StartTime:         aws.Time(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)),
+				EndTime:           aws.Time(time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)),
+			},
+		},
+		entities: []*health.AffectedEntity{
+			&health.AffectedEntity{EventArn: aws.String(testARN), EntityValue: aws.String("i-1"), StatusCode: aws.String("IMPAIRED")},
+		},
+		descriptions: map[string]string{testARN: "We are investigating increased error rates."},
+	}
+	e := &exporter{
+		api:      api,
+		jobs:     []*job{{filter: &health.EventFilter{}}},
+		entities: newAffectedEntities(api, time.Hour, false, false),
+		details:  newEventDetails(api, false),
+	}
+	e.refresh(context.Background(), e.jobs[0])
+	return &eventsAPI{exporter: e}
+}
+
+func TestEventsAPIList(t *testing.T) {
+	a := newTestEventsAPI(t)
+
+	tests := []struct {
+		query string
+		count int
+	}{
+		{"", 2},
+		{"?service=ec2", 1},
+		{"?service=EC2&service=LAMBDA", 2},
+		{"?status=closed", 1},
+		{"?scope=PUBLIC", 1},
+		{"?from=2023-05-01T00:00:00Z", 1},
+		{"?to=2023-05-01T00:00:00Z", 1},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		a.list(w, httptest.NewRequest("GET", "/api/v1/events"+test.query, nil))
+
+		var events []apiEvent
+		if err := json.NewDecoder(w.Body).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != test.count {
+			t.Errorf("%q: Expected %d events, got %d", test.query, test.count, len(events))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	a.list(w, httptest.NewRequest("GET", "/api/v1/events?from=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestEventsAPIGet(t *testing.T) {
+	a := newTestEventsAPI(t)
+
+	w := httptest.NewRecorder()
+	a.get(w, httptest.NewRequest("GET", "/api/v1/events/"+testARN, nil))
+
+	var event apiEvent
+	if err := json.NewDecoder(w.Body).Decode(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Description != "We are investigating increased error rates." {
+		t.Errorf("Invalid description: %q", event.Description)
+	}
+	if len(event.Entities) != 1 || event.Entities[0].EntityValue != "i-1" {
+		t.Errorf("Invalid entities: %v", event.Entities)
+	}
+
+	w = httptest.NewRecorder()
+	a.get(w, httptest.NewRequest("GET", "/api/v1/events/arn:unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestEventsAPIHistory(t *testing.T) {
+	s, err := openStore(filepath.Join(t.TempDir(), "events.db"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	a := &eventsAPI{exporter: &exporter{store: s}}
+
+	observed := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i, status := range []string{"open", "closed"} {
+		e := &health.Event{Arn: aws.String(testARN), StatusCode: aws.String(status), LastUpdatedTime: aws.Time(observed.Add(time.Duration(i) * time.Hour))}
+		if err := s.record(&snapshot{events: []*health.Event{e}, timestamp: observed.Add(time.Duration(i) * time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the history is served without a snapshot, e.g. after a restart
+	w := httptest.NewRecorder()
+	a.history(w, httptest.NewRequest("GET", "/api/v1/history/"+testARN, nil))
+	var h apiHistory
+	if err := json.NewDecoder(w.Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Versions) != 2 || h.Versions[1].Event.StatusCode != "closed" {
+		t.Errorf("Invalid versions: %v", h.Versions)
+	}
+	if len(h.Statuses) != 2 || !h.Statuses[1].Time.Equal(observed.Add(time.Hour)) {
+		t.Errorf("Invalid status changes: %v", h.Statuses)
+	}
+
+	w = httptest.NewRecorder()
+	a.history(w, httptest.NewRequest("GET", "/api/v1/history/arn:unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestEventsAPIWithoutSnapshot(t *testing.T) {
+	a := &eventsAPI{exporter: &exporter{}}
+
+	w := httptest.NewRecorder()
+	a.list(w, httptest.NewRequest("GET", "/api/v1/events", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"runtime"
+	"strings"
+	"sync"
 	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -34,8 +43,23 @@ const (
 	LabelService = "service"
 	// LabelStatusCode defines the status of the event, e.g. open, upcoming, closed
 	LabelStatusCode = "status_code"
+	// LabelARN defines the ARN of the event
+	LabelARN = "arn"
+	// LabelEventTypeCode defines the event type code, e.g. AWS_EC2_INSTANCE_RETIREMENT_SCHEDULED
+	LabelEventTypeCode = "event_type_code"
+	// LabelAvailabilityZone defines the availability zone of the event, e.g. us-east-1a
+	LabelAvailabilityZone = "availability_zone"
+	// LabelEventScopeCode defines the scope of the event, e.g. PUBLIC, ACCOUNT_SPECIFIC, NONE
+	LabelEventScopeCode = "event_scope_code"
 	// Namespace is the metrics prefix
 	Namespace = "aws_health"
+
+	// httpReadTimeout, httpWriteTimeout and httpIdleTimeout bound the HTTP
+	// connections, the write timeout leaves room for /probe requests that
+	// poll the AWS Health API
+	httpReadTimeout  = 10 * time.Second
+	httpWriteTimeout = 2 * time.Minute
+	httpIdleTimeout  = 2 * time.Minute
 )
 
 var (
@@ -53,68 +77,541 @@ var (
 		Namespace: Namespace,
 		Help:      "Gauge for aws health events",
 	}
+
+	// eventInfoLabels are the labels of the per-event info metric
+	eventInfoLabels = []string{LabelARN, LabelEventTypeCode, LabelCategory, LabelRegion, LabelAvailabilityZone, LabelService, LabelStatusCode, LabelEventScopeCode}
+
+	// eventInfoDesc is an info metric with one series per event
+	eventInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "event_info"),
+		"Info metric with one series per aws health event",
+		eventInfoLabels,
+		nil,
+	)
+
+	// eventStartTimeDesc, eventEndTimeDesc and eventLastUpdatedTimeDesc are
+	// the timestamps of every event, they join with aws_health_event_info on arn
+	eventStartTimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "event_start_time_seconds"),
+		"Unix time when the aws health event started",
+		[]string{LabelARN},
+		nil,
+	)
+	eventEndTimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "event_end_time_seconds"),
+		"Unix time when the aws health event ended, only set for events with an end time",
+		[]string{LabelARN},
+		nil,
+	)
+	eventLastUpdatedTimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "event_last_updated_time_seconds"),
+		"Unix time when the aws health event was last updated",
+		[]string{LabelARN},
+		nil,
+	)
+
+	// snapshotAgeDesc is the age of the event snapshot served by Collect
+	snapshotAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "snapshot_age_seconds"),
+		"Seconds since the last successful poll of the AWS Health API",
+		nil,
+		nil,
+	)
 )
 
+// snapshot is the result of the last successful poll of the AWS Health API
+type snapshot struct {
+	events []*health.Event
+	// accounts maps event ARNs to the affected account IDs, nil unless
+	// running in organization mode
+	accounts map[string][]string
+	// accountNames maps account IDs to the configured names, only set when
+	// polling the accounts of --config.file
+	accountNames map[string]string
+	// aggregates are the event counts that are not listed in events, only
+	// set in aggregate mode
+	aggregates []aggregateCount
+	timestamp  time.Time
+}
+
 type exporter struct {
-	api    healthiface.HealthAPI
-	filter *health.EventFilter
+	api healthiface.HealthAPI
+	// jobs are polled independently, the snapshot served to everything
+	// but aws_health_events is the union of their events
+	jobs []*job
+
+	// perEvent enables the per-event series, e.g. aws_health_event_info
+	perEvent bool
+
+	// org fetches the events of the whole organization, nil if disabled
+	org *organization
+	// accounts fetches the events of the accounts of --config.file with
+	// assumed roles, nil without accounts
+	accounts *accounts
+	// aggregate counts closed events with DescribeEventAggregates
+	aggregate bool
+
+	// entities and details are refreshed after every successful poll, nil
+	// if disabled
+	entities *affectedEntities
+	details  *eventDetails
+
+	// notifier observes every successful poll, nil without outputs
+	notifier *notifier
+	// store records the events of every successful poll, nil if disabled
+	store *store
+	// lifecycle counts the transitions between successful polls
+	lifecycle lifecycle
+
+	// refreshMu serializes the processing of the merged snapshots and the
+	// replacement of the jobs
+	refreshMu sync.Mutex
+	// polls are the running polls of the jobs
+	polls sync.WaitGroup
+
+	// mu guards jobs, accounts and the snapshots
+	mu   sync.RWMutex
+	last *snapshot
+	// complete is whether every job contributed to a merged snapshot once
+	complete bool
 }
 
 func (e *exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	ch <- prometheus.NewDesc(
 		prometheus.BuildFQName(eventOpts.Namespace, eventOpts.Subsystem, eventOpts.Name),
 		eventOpts.Help,
-		labels,
+		e.labels(),
 		nil,
 	)
+	ch <- snapshotAgeDesc
+	if e.perEvent {
+		ch <- eventInfoDesc
+		ch <- eventStartTimeDesc
+		ch <- eventEndTimeDesc
+		ch <- eventLastUpdatedTimeDesc
+		// the accounts can be configured on reload
+		ch <- eventAccountInfoDesc
+	}
+	ch <- accountInfoDesc
 }
 
+// Collect serves the metrics from the last snapshot and never calls the
+// AWS Health API itself, see poll.
 func (e *exporter) Collect(ch chan<- prometheus.Metric) {
-	gv := prometheus.NewGaugeVec(eventOpts, labels)
-	e.scrape(gv)
+	snap := e.snapshot()
+	if snap == nil {
+		return
+	}
+
+	e.mu.RLock()
+	names := jobLabels(e.jobs)
+	gv := prometheus.NewGaugeVec(eventOpts, e.labels())
+	for _, j := range e.jobs {
+		if j.last != nil {
+			countEvents(gv, j.last, j.labelValues(names)...)
+		}
+	}
+	acc := e.accounts
+	e.mu.RUnlock()
 	gv.Collect(ch)
+	if acc != nil {
+		acc.Collect(ch)
+	}
+
+	if e.perEvent {
+		collectPerEvent(ch, snap)
+	}
+
+	ch <- prometheus.MustNewConstMetric(snapshotAgeDesc, prometheus.GaugeValue, time.Since(snap.timestamp).Seconds())
+}
+
+// labels returns the labels of aws_health_events, in organization mode
+// and with accounts the events are additionally split by the affected
+// account and with --config.file jobs by job. It must be called with mu
+// held unless the exporter is not shared.
+func (e *exporter) labels() []string {
+	l := labels[:len(labels):len(labels)]
+	if e.org != nil {
+		l = append(l, LabelAccountID)
+	}
+	if e.accounts != nil {
+		l = append(l, LabelAccountID, LabelAccountName)
+	}
+	return append(l, jobLabels(e.jobs)...)
+}
+
+// snapshot returns the last successful snapshot or nil if there is none yet
+func (e *exporter) snapshot() *snapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.last
+}
+
+// poll refreshes the snapshot of j every interval of the job plus a random
+// jitter so that several exporter replicas don't hit the AWS Health API at
+// the same time. It returns when the job is stopped or ctx is done.
+func (e *exporter) poll(ctx context.Context, j *job, jitter time.Duration) {
+	defer e.polls.Done()
+	for {
+		e.refresh(ctx, j)
+
+		wait := j.interval
+		if jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(jitter)))
+		}
+		select {
+		case <-time.After(wait):
+		case <-j.stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// apply replaces the jobs and accounts and starts polling the new jobs,
+// e.g. on reload. A job with the name and filter of a previous one keeps
+// its snapshot unless the accounts changed, its poll keeps running if the
+// interval didn't change either. The merged snapshot is kept until every
+// new job polled. The polls end when ctx is done.
+func (e *exporter) apply(ctx context.Context, jobs []*job, acc *accounts, jitter time.Duration) {
+	// a refresh in progress finishes before the jobs are replaced
+	e.refreshMu.Lock()
+	defer e.refreshMu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	keep := sameAccounts(e.accounts, acc)
+	// the first jobs are no change
+	initial := len(e.jobs) == 0
+	changed := !keep || len(jobs) != len(e.jobs)
+	prev := map[string]*job{}
+	for _, j := range e.jobs {
+		prev[j.name] = j
+	}
+	names := map[string]bool{}
+	var started []*job
+	for i, j := range jobs {
+		names[j.name] = true
+		p, ok := prev[j.name]
+		if ok && keep && reflect.DeepEqual(p.filter, j.filter) {
+			if p.interval == j.interval {
+				// an unchanged job is not polled again before its interval
+				p.labels = j.labels
+				jobs[i] = p
+				delete(prev, j.name)
+				continue
+			}
+			j.last, j.failed = p.last, p.failed
+		} else {
+			changed = true
+		}
+		j.stop = make(chan struct{})
+		started = append(started, j)
+	}
+	for name, j := range prev {
+		close(j.stop)
+		if !names[name] {
+			scrapeDuration.DeleteLabelValues(name)
+		}
+	}
+	if changed && !initial {
+		// the events of new and removed jobs are no transitions
+		e.lifecycle.reset()
+	}
+	e.jobs, e.accounts = jobs, acc
+
+	if ctx.Err() != nil {
+		// shutting down
+		return
+	}
+	for _, j := range started {
+		e.polls.Add(1)
+		go e.poll(ctx, j, jitter)
+	}
+}
+
+// refresh replaces the snapshot of j with the current events and merges
+// the snapshots of all jobs. On error the previous snapshot is kept unless
+// the scrape returned a partial snapshot, e.g. if only some accounts failed.
+func (e *exporter) refresh(ctx context.Context, j *job) {
+	start := time.Now()
+	snap, err := e.scrape(ctx, j.filter)
+	scrapeDuration.WithLabelValues(j.name).Set(time.Since(start).Seconds())
+	if ctx.Err() != nil {
+		// the poll was canceled on shutdown
+		return
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	if snap == nil {
+		e.mu.Lock()
+		j.failed = true
+		e.mu.Unlock()
+		up.Set(0)
+		return
+	}
+	// with an error the snapshot is partial, e.g. some accounts failed
+	snap.timestamp = time.Now()
+	scrapeEvents.Add(float64(len(snap.events)))
+
+	// the jobs poll concurrently, the merged snapshots are processed in order
+	e.refreshMu.Lock()
+	defer e.refreshMu.Unlock()
+
+	select {
+	case <-j.stop:
+		// the job was replaced while polling
+		return
+	default:
+	}
+
+	e.mu.Lock()
+	j.last, j.failed = snap, false
+	merged, complete, failed := e.merge()
+	// after a reload an incomplete snapshot would drop the events of the
+	// jobs that didn't poll yet
+	if complete || !e.complete {
+		e.last = merged
+	}
+	e.complete = e.complete || complete
+	e.mu.Unlock()
+
+	if !failed {
+		up.Set(1)
+	}
+	lastSuccess.Set(float64(snap.timestamp.Unix()))
+
+	// until every job polled once the events of the others would look new
+	if !complete {
+		return
+	}
+	snap = merged
+	e.lifecycle.observe(snap)
+
+	if e.entities != nil {
+		if err := e.entities.refresh(ctx, snap); err != nil {
+			log.Println(err)
+		}
+	}
+	if e.details != nil {
+		if err := e.details.refresh(ctx, snap); err != nil {
+			log.Println(err)
+		}
+	}
+	if e.store != nil {
+		if err := e.store.record(snap); err != nil {
+			log.Println(err)
+		}
+	}
+	if e.notifier != nil {
+		e.notifier.observe(snap)
+	}
+}
+
+// merge returns the union of the snapshots of all jobs, whether every job
+// has a snapshot and whether the last poll of any job failed. The events
+// are deduplicated by ARN, the timestamp is the one of the oldest snapshot.
+// It must be called with mu held.
+func (e *exporter) merge() (merged *snapshot, complete, failed bool) {
+	if len(e.jobs) == 1 {
+		return e.jobs[0].last, true, e.jobs[0].failed
+	}
+
+	complete = true
+	seen := map[string]bool{}
+	for _, j := range e.jobs {
+		failed = failed || j.failed
+		if j.last == nil {
+			complete = false
+			continue
+		}
+		if merged == nil {
+			merged = &snapshot{timestamp: j.last.timestamp, accountNames: j.last.accountNames}
+		}
+		if j.last.timestamp.Before(merged.timestamp) {
+			merged.timestamp = j.last.timestamp
+		}
+
+		for _, ev := range j.last.events {
+			arn := aws.StringValue(ev.Arn)
+			if seen[arn] {
+				continue
+			}
+			seen[arn] = true
+			merged.events = append(merged.events, ev)
+		}
+		for arn, accounts := range j.last.accounts {
+			if merged.accounts == nil {
+				merged.accounts = map[string][]string{}
+			}
+			if _, ok := merged.accounts[arn]; !ok {
+				merged.accounts[arn] = accounts
+			}
+		}
+	}
+	return merged, complete, failed
 }
 
-func (e *exporter) scrape(gv *prometheus.GaugeVec) {
+func (e *exporter) scrape(ctx context.Context, f *health.EventFilter) (*snapshot, error) {
+	if e.org != nil {
+		return e.org.scrape(ctx, f)
+	}
+	e.mu.RLock()
+	acc := e.accounts
+	e.mu.RUnlock()
+	if acc != nil {
+		return acc.scrape(ctx, f)
+	}
+	if e.aggregate {
+		return e.scrapeAggregated(ctx, f)
+	}
+
+	events, err := e.describeEvents(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot{events: events}, nil
+}
+
+func (e *exporter) describeEvents(ctx context.Context, f *health.EventFilter) ([]*health.Event, error) {
 	var events []*health.Event
 
-	err := e.api.DescribeEventsPages(&health.DescribeEventsInput{
-		Filter: e.filter,
+	err := e.api.DescribeEventsPagesWithContext(ctx, &health.DescribeEventsInput{
+		Filter: f,
 	}, func(out *health.DescribeEventsOutput, lastPage bool) bool {
+		scrapePages.Inc()
 		events = append(events, out.Events...)
 		return true
 	})
 
-	if err != nil {
-		log.Println(err)
-		return
+	return events, err
+}
+
+// countEvents counts the events of snap by the labels of aws_health_events.
+// In organization mode an event is counted once per affected account and
+// with an empty account for events that are not account specific, with
+// accounts once per account that reported it. In
+// aggregate mode the aggregated counts are added. extra are the values of
+// the job labels.
+func countEvents(gv *prometheus.GaugeVec, snap *snapshot, extra ...string) {
+	for _, e := range snap.events {
+		values := []string{
+			aws.StringValue(e.EventTypeCategory),
+			aws.StringValue(e.Region),
+			aws.StringValue(e.Service),
+			aws.StringValue(e.StatusCode),
+		}
+		if snap.accounts == nil {
+			gv.WithLabelValues(append(values, extra...)...).Inc()
+			continue
+		}
+
+		accounts := snap.accounts[aws.StringValue(e.Arn)]
+		if len(accounts) == 0 {
+			accounts = []string{""}
+		}
+		for _, id := range accounts {
+			v := append(values, id)
+			if snap.accountNames != nil {
+				v = append(v, snap.accountNames[id])
+			}
+			gv.WithLabelValues(append(v, extra...)...).Inc()
+		}
+	}
+
+	for _, a := range snap.aggregates {
+		gv.WithLabelValues(append([]string{a.category, a.region, a.service, a.statusCode}, extra...)...).Add(a.count)
 	}
+}
 
-	for _, e := range events {
-		gv.WithLabelValues(
+func collectPerEvent(ch chan<- prometheus.Metric, snap *snapshot) {
+	for _, e := range snap.events {
+		arn := aws.StringValue(e.Arn)
+		ch <- prometheus.MustNewConstMetric(eventInfoDesc, prometheus.GaugeValue, 1,
+			arn,
+			aws.StringValue(e.EventTypeCode),
 			aws.StringValue(e.EventTypeCategory),
 			aws.StringValue(e.Region),
+			aws.StringValue(e.AvailabilityZone),
 			aws.StringValue(e.Service),
-			aws.StringValue(e.StatusCode)).Inc()
+			aws.StringValue(e.StatusCode),
+			aws.StringValue(e.EventScopeCode))
+
+		collectTime(ch, eventStartTimeDesc, e.StartTime, arn)
+		collectTime(ch, eventEndTimeDesc, e.EndTime, arn)
+		collectTime(ch, eventLastUpdatedTimeDesc, e.LastUpdatedTime, arn)
+
+		for _, id := range snap.accounts[arn] {
+			ch <- prometheus.MustNewConstMetric(eventAccountInfoDesc, prometheus.GaugeValue, 1, arn, id)
+		}
 	}
 }
 
+// collectTime sends t as unix time, nothing is sent if t is not set
+func collectTime(ch chan<- prometheus.Metric, desc *prometheus.Desc, t *time.Time, labelValues ...string) {
+	if t == nil || t.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(t.UnixNano())/1e9, labelValues...)
+}
+
 func init() {
 	prometheus.MustRegister(version.NewCollector("aws_health_exporter"))
 }
 
 func main() {
 	var (
-		showVersion = kingpin.Flag("version", "Print version information").Bool()
-		listenAddr  = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9383").String()
-		categories  = kingpin.Flag("aws.category", "A list of event type category codes (issue, scheduledChange, or accountNotification) that are used to filter events.").Strings()
-		regions     = kingpin.Flag("aws.region", "A list of AWS regions that are used to filter events").Strings()
-		services    = kingpin.Flag("aws.service", "A list of AWS services that are used to filter events").Strings()
-	)
+		showVersion        = kingpin.Flag("version", "Print version information").Bool()
+		listenAddr         = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9383").String()
+		webConfigFile      = kingpin.Flag("web.config.file", "YAML file in the format of the Prometheus exporter-toolkit that enables TLS and authentication.").String()
+		enableLifecycle    = kingpin.Flag("web.enable-lifecycle", "Enable the /-/reload endpoint that reloads the configuration on POST.").Bool()
+		categories         = kingpin.Flag("aws.category", "A list of event type category codes (issue, scheduledChange, or accountNotification) that are used to filter events.").Strings()
+		regions            = kingpin.Flag("aws.region", "A list of AWS regions that are used to filter events").Strings()
+		services           = kingpin.Flag("aws.service", "A list of AWS services that are used to filter events").Strings()
+		typeCodes          = kingpin.Flag("aws.event-type-code", "A list of event type codes, e.g. AWS_EC2_INSTANCE_RETIREMENT_SCHEDULED, that are used to filter events").Strings()
+		interval           = kingpin.Flag("aws.poll-interval", "How often the AWS Health API is polled for events.").Default("1m").Duration()
+		jitter             = kingpin.Flag("aws.poll-jitter", "Maximum random delay added to every poll interval.").Default("10s").Duration()
+		perEvent           = kingpin.Flag("metrics.per-event", "Export per-event series, e.g. aws_health_event_info, with one series per event ARN.").Bool()
+		perEntity          = kingpin.Flag("metrics.per-entity", "Export aws_health_affected_entity_info with one series per affected entity. Requires --aws.affected-entities.").Bool()
+		entities           = kingpin.Flag("aws.affected-entities", "Fetch the entities affected by open and upcoming events.").Bool()
+		entitiesTTL        = kingpin.Flag("aws.affected-entities-ttl", "How long the affected entities of an event are cached.").Default("5m").Duration()
+		details            = kingpin.Flag("aws.event-details", "Fetch the description of open, upcoming and updated events.").Bool()
+		orgMode            = kingpin.Flag("aws.organization", "Fetch the events of all accounts of the AWS organization. Requires the organizational view of AWS Health.").Bool()
+		orgInclude         = kingpin.Flag("aws.organization.account", "A list of AWS account IDs the events are limited to in organization mode").Strings()
+		orgExclude         = kingpin.Flag("aws.organization.exclude-account", "A list of AWS account IDs that are ignored in organization mode").Strings()
+		entityAggs         = kingpin.Flag("aws.entity-aggregates", "Count the entities affected by open and upcoming events with DescribeEntityAggregates. Not supported in organization mode.").Bool()
+		entityAggsInterval = kingpin.Flag("aws.entity-aggregates-interval", "How often the entity counts are refreshed.").Default("5m").Duration()
+		eventTypesOn       = kingpin.Flag("aws.event-types", "Fetch the catalog of event types, export it and validate --aws.service and --aws.event-type-code against it at startup.").Bool()
+		eventTypesInterval = kingpin.Flag("aws.event-types-interval", "How often the catalog of event types is refreshed.").Default("1h").Duration()
+		webhookURLs        = kingpin.Flag("notify.webhook.url", "A list of URLs that event transitions (new, updated, closed) are POSTed to as JSON").Strings()
+		webhookSecretFile  = kingpin.Flag("notify.webhook.secret-file", "File with the secret the webhook requests are signed with (HMAC-SHA256 in the X-Signature-256 header).").String()
+		slackURL           = kingpin.Flag("notify.slack.webhook-url", "Slack incoming webhook URL that event transitions are posted to").String()
+		slackTokenFile     = kingpin.Flag("notify.slack.token-file", "File with a Slack bot token, event transitions are posted with chat.postMessage and threaded per event").String()
+		slackChannel       = kingpin.Flag("notify.slack.channel", "Slack channel the messages are posted to with --notify.slack.token-file").String()
+		alertmanagerURLs   = kingpin.Flag("notify.alertmanager.url", "A list of Alertmanager URLs that an alert per open event is pushed to").Strings()
+		storePath          = kingpin.Flag("store.path", "File the history of the events is persisted in. Disabled if empty.").String()
+		storeRetention     = kingpin.Flag("store.retention", "How long events that are no longer reported by the AWS Health API are kept in the store.").Default("2160h").Duration()
+		storeCompaction    = kingpin.Flag("store.compaction-interval", "How often expired events are deleted from the store and the file is compacted.").Default("24h").Duration()
+		notifyTimeout      = kingpin.Flag("notify.timeout", "Timeout of a single notification request.").Default("10s").Duration()
+		notifyRetries      = kingpin.Flag("notify.retries", "Number of retries of failed notification requests.").Default("3").Int()
+		configFile         = kingpin.Flag("config.file", "YAML file with named jobs, each with its own event filter, poll interval and labels. Replaces the event filter flags.").String()
+		probeCacheTTL      = kingpin.Flag("probe.cache-ttl", "How long the events of a target and module of /probe are cached.").Default("1m").Duration()
+		shutdownTimeout    = kingpin.Flag("shutdown.timeout", "How long running HTTP requests and queued notifications are waited for on shutdown.").Default("25s").Duration()
+		aggregate          = kingpin.Flag("aws.aggregate", "Count closed events with DescribeEventAggregates instead of listing them. Only effective with --aws.region and --aws.service. Not supported in organization mode.").Bool()
 
-	registerSignals()
+		serveCmd = kingpin.Command("serve", "Run the exporter.").Default()
+		orgCmd   = kingpin.Command("org", "Manage the organizational view of AWS Health.")
+	)
+	orgCmd.Command("status", "Print the status of the organizational view.")
+	orgCmd.Command("enable", "Enable the organizational view.")
+	orgCmd.Command("disable", "Disable the organizational view.")
 
-	kingpin.Parse()
+	cmd := kingpin.Parse()
 
 	if *showVersion {
 		tw := tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', 0)
@@ -125,13 +622,27 @@ func main() {
 		os.Exit(0)
 	}
 
-	log.Printf("Starting `aws-health-exporter`: Build Time: '%s' Build SHA-1: '%s'\n", BuildTime, Version)
-
 	sess, err := session.NewSession(&aws.Config{Region: aws.String(APIRegion)})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	api := health.New(sess)
+	instrumentHandlers(&api.Handlers)
+
+	if cmd != serveCmd.FullCommand() {
+		status, err := runOrgCommand(context.Background(), api, strings.TrimPrefix(cmd, orgCmd.FullCommand()+" "))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(status)
+		os.Exit(0)
+	}
+
+	log.Printf("Starting `aws-health-exporter`: Build Time: '%s' Build SHA-1: '%s'\n", BuildTime, Version)
+
+	ctx := registerSignals()
+
 	filter := &health.EventFilter{}
 	if len(*categories) > 0 {
 		filter.EventTypeCategories = aws.StringSlice(*categories)
@@ -142,31 +653,280 @@ func main() {
 	if len(*services) > 0 {
 		filter.Services = aws.StringSlice(*services)
 	}
+	if len(*typeCodes) > 0 {
+		filter.EventTypeCodes = aws.StringSlice(*typeCodes)
+	}
+	filterFlags := len(*categories)+len(*regions)+len(*services)+len(*typeCodes) > 0
+
+	if *orgMode && *aggregate {
+		log.Fatal("--aws.aggregate is not supported in organization mode")
+	}
+	if *orgMode && *entityAggs {
+		log.Fatal("--aws.entity-aggregates is not supported in organization mode")
+	}
+
+	var web *webConfig
+	if *webConfigFile != "" {
+		web, err = loadWebConfig(*webConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var types *eventTypes
+	if *eventTypesOn {
+		types = newEventTypes(api)
+		if err := types.refresh(ctx); err != nil {
+			log.Fatal(err)
+		}
+		prometheus.MustRegister(types)
+		go types.run(ctx, *eventTypesInterval)
+	}
+
+	// load reads the configuration that can be reloaded, see reloader
+	load := func() (*settings, error) {
+		s := &settings{jobs: []*job{{filter: filter, interval: *interval}}}
+		if *configFile != "" {
+			c, err := loadConfig(*configFile)
+			if err != nil {
+				return nil, err
+			}
+			if len(c.Jobs) > 0 {
+				if filterFlags {
+					return nil, errors.New("the event filter flags can't be combined with the jobs of --config.file, use the filter of the jobs")
+				}
+				s.jobs = c.jobs(*interval)
+				if *orgMode {
+					for _, j := range s.jobs {
+						if err := checkOrgFilter(j.filter); err != nil {
+							return nil, fmt.Errorf("job %s: %v", j.name, err)
+						}
+					}
+				}
+			}
+			if len(c.Accounts) > 0 {
+				// these use the API of the exporter's own account
+				unsupported := map[string]bool{
+					"--aws.organization":      *orgMode,
+					"--aws.aggregate":         *aggregate,
+					"--aws.affected-entities": *entities,
+					"--aws.entity-aggregates": *entityAggs,
+					"--aws.event-details":     *details,
+				}
+				for flag, set := range unsupported {
+					if set {
+						return nil, fmt.Errorf("%s is not supported with the accounts of --config.file", flag)
+					}
+				}
+				s.accounts = newAccounts(sess, c.Accounts)
+			}
+		}
 
-	exporter := &exporter{api: health.New(sess), filter: filter}
+		if types != nil {
+			for _, j := range s.jobs {
+				if err := types.validate(j.filter); err != nil {
+					if j.name != "" {
+						return nil, fmt.Errorf("job %s: %v", j.name, err)
+					}
+					return nil, err
+				}
+			}
+		}
+
+		if len(*webhookURLs) > 0 {
+			var secret []byte
+			if *webhookSecretFile != "" {
+				b, err := ioutil.ReadFile(*webhookSecretFile)
+				if err != nil {
+					return nil, err
+				}
+				secret = bytes.TrimSpace(b)
+			}
+			for _, url := range *webhookURLs {
+				s.outputs = append(s.outputs, newWebhook(url, secret, *notifyTimeout, *notifyRetries))
+			}
+		}
+		if *slackURL != "" {
+			s.outputs = append(s.outputs, newSlackWebhook(*slackURL, *notifyTimeout, *notifyRetries))
+		}
+		if *slackTokenFile != "" {
+			token, err := ioutil.ReadFile(*slackTokenFile)
+			if err != nil {
+				return nil, err
+			}
+			if *slackChannel == "" {
+				return nil, errors.New("--notify.slack.channel is required with --notify.slack.token-file")
+			}
+			s.outputs = append(s.outputs, newSlackBot(string(bytes.TrimSpace(token)), *slackChannel, *notifyTimeout, *notifyRetries))
+		}
+		maxInterval := *interval
+		for _, j := range s.jobs {
+			if j.interval > maxInterval {
+				maxInterval = j.interval
+			}
+		}
+		for _, url := range *alertmanagerURLs {
+			// like Prometheus the alerts are valid for four intervals
+			s.outputs = append(s.outputs, newAlertmanager(url, 4*(maxInterval+*jitter), *notifyTimeout, *notifyRetries))
+		}
+		return s, nil
+	}
+	initial, err := load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	exporter := &exporter{api: api, perEvent: *perEvent, aggregate: *aggregate, lifecycle: lifecycle{aggregate: *aggregate}}
+	if *orgMode {
+		exporter.org = newOrganization(api, *orgInclude, *orgExclude)
+		if err := exporter.org.checkStatus(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *entities {
+		exporter.entities = newAffectedEntities(api, *entitiesTTL, *perEntity, *orgMode)
+		prometheus.MustRegister(exporter.entities)
+	}
+	if *details {
+		exporter.details = newEventDetails(api, *orgMode)
+	}
 	prometheus.MustRegister(exporter)
 
+	var workers sync.WaitGroup
+	if *storePath != "" {
+		exporter.store, err = openStore(*storePath, *storeRetention)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the counters include the transitions while the exporter was down
+		exporter.lifecycle.prev, err = exporter.store.latest()
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the store is closed once its worker returned
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			exporter.store.run(ctx, exporter, *storeCompaction)
+		}()
+	}
+
+	eventsAPI := &eventsAPI{exporter: exporter}
+
+	// the outputs are only replaced on reload, the flags decide whether there are any
+	if len(initial.outputs) > 0 {
+		exporter.notifier = newNotifier(eventsAPI, nil)
+		if exporter.store != nil {
+			// transitions while the exporter was down are notified on the first poll
+			exporter.notifier.prev, err = exporter.store.latest()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		go exporter.notifier.run()
+	}
+
+	prober := newProber(exporter, nil, nil, *probeCacheTTL)
+	reloader := newReloader(ctx, load, exporter, prober, *jitter)
+	reloader.apply(initial)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloader.watch(hup)
+
+	if *entityAggs {
+		aggs := newEntityAggregates(api)
+		prometheus.MustRegister(aggs)
+		go aggs.run(ctx, exporter, *entityAggsInterval)
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-             <head><title>AWS Health Exporter</title></head>
-             <body>
-             <h1>AWS Health Exporter</h1>
-             <p><a href='/metrics'>Metrics</a></p>
-             </body>
-             </html>`))
-	})
-	log.Println("Listening on", *listenAddr)
-	http.ListenAndServe(*listenAddr, mux)
+	if types != nil {
+		mux.Handle("/api/v1/event-types", types)
+	}
+	mux.HandleFunc("/api/v1/events", eventsAPI.list)
+	mux.HandleFunc("/api/v1/events/", eventsAPI.get)
+	mux.HandleFunc("/api/v1/resolution-times", eventsAPI.resolutionTimes)
+	if exporter.store != nil {
+		mux.HandleFunc("/api/v1/history/", eventsAPI.history)
+	}
+	mux.Handle("/probe", prober)
+	if *enableLifecycle {
+		mux.Handle("/-/reload", reloader)
+	}
+	dashboard := &dashboard{api: eventsAPI}
+	mux.HandleFunc("/", dashboard.index)
+	mux.HandleFunc("/events/", dashboard.event)
+
+	server := &http.Server{
+		Addr:         *listenAddr,
+		Handler:      mux,
+		ReadTimeout:  httpReadTimeout,
+		WriteTimeout: httpWriteTimeout,
+		IdleTimeout:  httpIdleTimeout,
+	}
+	if web != nil {
+		if err := web.apply(server); err != nil {
+			log.Fatal(err)
+		}
+	}
+	go func() {
+		log.Println("Listening on", *listenAddr)
+		var err error
+		if server.TLSConfig != nil {
+			// the certificate is loaded by the TLS config
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdown(server, exporter, &workers, *shutdownTimeout)
 }
 
-func registerSignals() {
+// registerSignals returns a context that is canceled on SIGINT or SIGTERM,
+// a second signal exits immediately
+func registerSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		log.Print("Received SIGTERM, exiting...")
+		log.Print("Received SIGTERM, shutting down...")
+		cancel()
+		<-c
+		log.Print("Received second signal, exiting...")
 		os.Exit(1)
 	}()
+	return ctx
+}
+
+// shutdown stops the HTTP server, waits for the canceled polls and
+// workers, sends the queued notifications and closes the store. Requests
+// and notifications that don't finish within timeout are dropped.
+func shutdown(server *http.Server, e *exporter, workers *sync.WaitGroup, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+	e.polls.Wait()
+	workers.Wait()
+	if e.notifier != nil {
+		if err := e.notifier.drain(ctx); err != nil {
+			log.Println(err)
+		}
+	}
+	if e.store != nil {
+		if err := e.store.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+	log.Println("Shutdown complete")
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/health"
 	"github.com/aws/aws-sdk-go/service/health/healthiface"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,15 +16,184 @@ import (
 
 type mockHealthAPI struct {
 	healthiface.HealthAPI
-	events []*health.Event
+	events     []*health.Event
+	entities   []*health.AffectedEntity
+	eventTypes []*health.EventType
+	// descriptions maps event ARNs to their latest description
+	descriptions map[string]string
+	// accounts maps event ARNs to the affected accounts in organization mode
+	accounts map[string][]string
+	// orgStatus is the status of the organizational view, ENABLED if empty
+	orgStatus string
+	err       error
+
+	// calls counts the API calls per operation
+	calls map[string]int
+}
+
+func (api *mockHealthAPI) called(op string) {
+	if api.calls == nil {
+		api.calls = map[string]int{}
+	}
+	api.calls[op]++
+}
+
+func (api *mockHealthAPI) DescribeAffectedEntitiesPagesWithContext(ctx aws.Context, in *health.DescribeAffectedEntitiesInput, fn func(*health.DescribeAffectedEntitiesOutput, bool) bool, opts ...request.Option) error {
+	api.called("DescribeAffectedEntities")
+	if api.err != nil {
+		return api.err
+	}
+	arns := map[string]bool{}
+	for _, arn := range in.Filter.EventArns {
+		arns[aws.StringValue(arn)] = true
+	}
+	var entities []*health.AffectedEntity
+	for _, e := range api.entities {
+		if arns[aws.StringValue(e.EventArn)] {
+			entities = append(entities, e)
+		}
+	}
+	fn(&health.DescribeAffectedEntitiesOutput{Entities: entities}, true)
+	return nil
+}
+
+func (api *mockHealthAPI) DescribeEventDetailsWithContext(ctx aws.Context, in *health.DescribeEventDetailsInput, opts ...request.Option) (*health.DescribeEventDetailsOutput, error) {
+	api.called("DescribeEventDetails")
+	if api.err != nil {
+		return nil, api.err
+	}
+	out := &health.DescribeEventDetailsOutput{}
+	for _, arn := range in.EventArns {
+		out.SuccessfulSet = append(out.SuccessfulSet, &health.EventDetails{
+			Event:            &health.Event{Arn: arn},
+			EventDescription: &health.EventDescription{LatestDescription: aws.String(api.descriptions[aws.StringValue(arn)])},
+		})
+	}
+	return out, nil
+}
+
+func (api *mockHealthAPI) DescribeEventTypesPagesWithContext(ctx aws.Context, in *health.DescribeEventTypesInput, fn func(*health.DescribeEventTypesOutput, bool) bool, opts ...request.Option) error {
+	api.called("DescribeEventTypes")
+	if api.err != nil {
+		return api.err
+	}
+	fn(&health.DescribeEventTypesOutput{EventTypes: api.eventTypes}, true)
+	return nil
+}
+
+func (api *mockHealthAPI) DescribeEntityAggregatesWithContext(ctx aws.Context, in *health.DescribeEntityAggregatesInput, opts ...request.Option) (*health.DescribeEntityAggregatesOutput, error) {
+	api.called("DescribeEntityAggregates")
+	if api.err != nil {
+		return nil, api.err
+	}
+	counts := map[string]int64{}
+	for _, e := range api.entities {
+		counts[aws.StringValue(e.EventArn)]++
+	}
+	out := &health.DescribeEntityAggregatesOutput{}
+	for _, arn := range in.EventArns {
+		out.EntityAggregates = append(out.EntityAggregates, &health.EntityAggregate{EventArn: arn, Count: aws.Int64(counts[aws.StringValue(arn)])})
+	}
+	return out, nil
 }
 
-func (api *mockHealthAPI) DescribeEventsPages(in *health.DescribeEventsInput, fn func(*health.DescribeEventsOutput, bool) bool) error {
-	output := health.DescribeEventsOutput{Events: api.events}
+func (api *mockHealthAPI) DescribeEventsForOrganizationPagesWithContext(ctx aws.Context, in *health.DescribeEventsForOrganizationInput, fn func(*health.DescribeEventsForOrganizationOutput, bool) bool, opts ...request.Option) error {
+	api.called("DescribeEventsForOrganization")
+	if api.err != nil {
+		return api.err
+	}
+	var events []*health.OrganizationEvent
+	for _, e := range api.events {
+		events = append(events, &health.OrganizationEvent{
+			Arn:               e.Arn,
+			EventScopeCode:    e.EventScopeCode,
+			EventTypeCategory: e.EventTypeCategory,
+			Region:            e.Region,
+			Service:           e.Service,
+			StatusCode:        e.StatusCode,
+		})
+	}
+	fn(&health.DescribeEventsForOrganizationOutput{Events: events}, true)
+	return nil
+}
+
+func (api *mockHealthAPI) DescribeAffectedAccountsForOrganizationPagesWithContext(ctx aws.Context, in *health.DescribeAffectedAccountsForOrganizationInput, fn func(*health.DescribeAffectedAccountsForOrganizationOutput, bool) bool, opts ...request.Option) error {
+	api.called("DescribeAffectedAccountsForOrganization")
+	fn(&health.DescribeAffectedAccountsForOrganizationOutput{
+		AffectedAccounts: aws.StringSlice(api.accounts[aws.StringValue(in.EventArn)]),
+	}, true)
+	return nil
+}
+
+func (api *mockHealthAPI) DescribeHealthServiceStatusForOrganizationWithContext(ctx aws.Context, in *health.DescribeHealthServiceStatusForOrganizationInput, opts ...request.Option) (*health.DescribeHealthServiceStatusForOrganizationOutput, error) {
+	api.called("DescribeHealthServiceStatusForOrganization")
+	status := api.orgStatus
+	if status == "" {
+		status = "ENABLED"
+	}
+	return &health.DescribeHealthServiceStatusForOrganizationOutput{HealthServiceAccessStatusForOrganization: aws.String(status)}, nil
+}
+
+func (api *mockHealthAPI) EnableHealthServiceAccessForOrganizationWithContext(ctx aws.Context, in *health.EnableHealthServiceAccessForOrganizationInput, opts ...request.Option) (*health.EnableHealthServiceAccessForOrganizationOutput, error) {
+	api.called("EnableHealthServiceAccessForOrganization")
+	api.orgStatus = "ENABLED"
+	return &health.EnableHealthServiceAccessForOrganizationOutput{}, nil
+}
+
+func (api *mockHealthAPI) DisableHealthServiceAccessForOrganizationWithContext(ctx aws.Context, in *health.DisableHealthServiceAccessForOrganizationInput, opts ...request.Option) (*health.DisableHealthServiceAccessForOrganizationOutput, error) {
+	api.called("DisableHealthServiceAccessForOrganization")
+	api.orgStatus = "DISABLED"
+	return &health.DisableHealthServiceAccessForOrganizationOutput{}, nil
+}
+
+func (api *mockHealthAPI) DescribeEventsPagesWithContext(ctx aws.Context, in *health.DescribeEventsInput, fn func(*health.DescribeEventsOutput, bool) bool, opts ...request.Option) error {
+	api.called("DescribeEvents")
+	if api.err != nil {
+		return api.err
+	}
+	output := health.DescribeEventsOutput{Events: api.filtered(in.Filter)}
 	fn(&output, false)
 	return nil
 }
 
+func (api *mockHealthAPI) DescribeEventAggregatesPagesWithContext(ctx aws.Context, in *health.DescribeEventAggregatesInput, fn func(*health.DescribeEventAggregatesOutput, bool) bool, opts ...request.Option) error {
+	api.called("DescribeEventAggregates")
+	counts := map[string]int64{}
+	for _, e := range api.filtered(in.Filter) {
+		counts[aws.StringValue(e.EventTypeCategory)]++
+	}
+	var aggregates []*health.EventAggregate
+	for category, n := range counts {
+		aggregates = append(aggregates, &health.EventAggregate{AggregateValue: aws.String(category), Count: aws.Int64(n)})
+	}
+	fn(&health.DescribeEventAggregatesOutput{EventAggregates: aggregates}, true)
+	return nil
+}
+
+// filtered returns the events matching the status codes, regions and services of f
+func (api *mockHealthAPI) filtered(f *health.EventFilter) []*health.Event {
+	matches := func(values []*string, v *string) bool {
+		if len(values) == 0 {
+			return true
+		}
+		for _, value := range values {
+			if aws.StringValue(value) == aws.StringValue(v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var events []*health.Event
+	for _, e := range api.events {
+		if f != nil && (!matches(f.EventStatusCodes, e.StatusCode) || !matches(f.Regions, e.Region) || !matches(f.Services, e.Service)) {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
 func TestScrape(t *testing.T) {
 	var events = []*health.Event{
 		&health.Event{
@@ -55,18 +228,136 @@ func TestScrape(t *testing.T) {
 		},
 	}
 	e := &exporter{
-		api:    &mockHealthAPI{events: events},
-		filter: &health.EventFilter{},
+		api:  &mockHealthAPI{events: events},
+		jobs: []*job{{filter: &health.EventFilter{}}},
+	}
+
+	scraped, err := e.scrape(context.Background(), e.jobs[0].filter)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	gv := prometheus.NewGaugeVec(eventOpts, labels)
-	e.scrape(gv)
+	countEvents(gv, scraped)
 
 	validateMetric(t, gv, events[0], 1.)
 	validateMetric(t, gv, events[1], 1.)
 	validateMetric(t, gv, events[2], 3.)
 }
 
+func TestRefreshKeepsSnapshotOnError(t *testing.T) {
+	api := &mockHealthAPI{events: []*health.Event{
+		&health.Event{
+			EventTypeCategory: aws.String("issue"),
+			Region:            aws.String("eu-west-1"),
+			Service:           aws.String("EC2"),
+			StatusCode:        aws.String("open"),
+		},
+	}}
+	e := &exporter{api: api, jobs: []*job{{filter: &health.EventFilter{}}}}
+
+	if n := collectCount(e); n != 0 {
+		t.Errorf("Expected no metrics before the first poll, got %d", n)
+	}
+
+	e.refresh(context.Background(), e.jobs[0])
+	first := e.snapshot()
+	if first == nil || len(first.events) != 1 {
+		t.Fatalf("Expected a snapshot with 1 event, got %v", first)
+	}
+
+	api.err = errors.New("ThrottlingException")
+	e.refresh(context.Background(), e.jobs[0])
+	if e.snapshot() != first {
+		t.Errorf("Expected the previous snapshot to be kept on error")
+	}
+
+	// one event series and the snapshot age
+	if n := collectCount(e); n != 2 {
+		t.Errorf("Expected 2 metrics, got %d", n)
+	}
+}
+
+func TestPollShutdown(t *testing.T) {
+	api := &mockHealthAPI{events: []*health.Event{{Arn: aws.String("arn:event/ec2"), StatusCode: aws.String("open")}}}
+	e := &exporter{api: api}
+	ctx, cancel := context.WithCancel(context.Background())
+	e.apply(ctx, []*job{{filter: &health.EventFilter{}, interval: time.Hour}}, nil, 0)
+
+	for deadline := time.Now().Add(time.Second); e.snapshot() == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a snapshot of the first poll")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	e.polls.Wait()
+
+	// a poll canceled on shutdown is not a failure
+	e.refresh(ctx, e.jobs[0])
+	if e.jobs[0].failed {
+		t.Errorf("Expected the canceled poll to be ignored")
+	}
+}
+
+func TestCollectEventInfo(t *testing.T) {
+	api := &mockHealthAPI{events: []*health.Event{
+		&health.Event{
+			Arn:               aws.String("arn:aws:health:eu-west-1::event/EC2/AWS_EC2_INSTANCE_RETIREMENT_SCHEDULED/1"),
+			EventTypeCode:     aws.String("AWS_EC2_INSTANCE_RETIREMENT_SCHEDULED"),
+			EventTypeCategory: aws.String("scheduledChange"),
+			EventScopeCode:    aws.String("ACCOUNT_SPECIFIC"),
+			Region:            aws.String("eu-west-1"),
+			AvailabilityZone:  aws.String("eu-west-1a"),
+			Service:           aws.String("EC2"),
+			StatusCode:        aws.String("upcoming"),
+			StartTime:         aws.Time(time.Unix(1700000000, 0)),
+			LastUpdatedTime:   aws.Time(time.Unix(1690000000, 0)),
+		},
+	}}
+
+	e := &exporter{api: api, jobs: []*job{{filter: &health.EventFilter{}}}}
+	e.refresh(context.Background(), e.jobs[0])
+	if n := collectCount(e); n != 2 {
+		t.Errorf("Expected 2 metrics without per-event series, got %d", n)
+	}
+
+	e.perEvent = true
+	ch := make(chan prometheus.Metric, 100)
+	collectPerEvent(ch, &snapshot{events: api.events})
+	close(ch)
+
+	pb := &dto.Metric{}
+	(<-ch).Write(pb)
+	got := map[string]string{}
+	for _, l := range pb.GetLabel() {
+		got[l.GetName()] = l.GetValue()
+	}
+	if got[LabelEventTypeCode] != "AWS_EC2_INSTANCE_RETIREMENT_SCHEDULED" || got[LabelAvailabilityZone] != "eu-west-1a" || got[LabelEventScopeCode] != "ACCOUNT_SPECIFIC" {
+		t.Errorf("Invalid labels: %v", got)
+	}
+	// info, start and last updated time, the end time is not set
+	if n := len(ch); n != 2 {
+		t.Errorf("Expected 2 remaining per-event metrics, got %d", n)
+	}
+	pb = &dto.Metric{}
+	(<-ch).Write(pb)
+	if v := pb.GetGauge().GetValue(); v != 1700000000 {
+		t.Errorf("Invalid start time - Expected: 1700000000 Got: %v", v)
+	}
+
+	if n := collectCount(e); n != 5 {
+		t.Errorf("Expected 5 metrics with per-event series, got %d", n)
+	}
+}
+
+func collectCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
 func validateMetric(t *testing.T, vec *prometheus.GaugeVec, e *health.Event, expectedVal float64) {
 	m := vec.WithLabelValues(*e.EventTypeCategory, *e.Region, *e.Service, *e.StatusCode)
 	pb := &dto.Metric{}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// LabelFilter defines the name of the job an event count belongs to, it is
+// not called job to not clash with the job label of Prometheus
+const LabelFilter = "filter"
+
+// config is the file given with --config.file
+type config struct {
+	Jobs     []*jobConfig     `yaml:"jobs"`
+	Accounts []*accountConfig `yaml:"accounts"`
+}
+
+// jobConfig is a named view of the events with its own filter
+type jobConfig struct {
+	Name string `yaml:"name"`
+	// PollInterval defaults to --aws.poll-interval
+	PollInterval time.Duration     `yaml:"poll_interval"`
+	Labels       map[string]string `yaml:"labels"`
+	Filter       filterConfig      `yaml:"filter"`
+}
+
+// filterConfig is the YAML representation of health.EventFilter
+type filterConfig struct {
+	AvailabilityZones   []string            `yaml:"availability_zones"`
+	EndTimes            []timeRange         `yaml:"end_times"`
+	EntityArns          []string            `yaml:"entity_arns"`
+	EntityValues        []string            `yaml:"entity_values"`
+	EventArns           []string            `yaml:"event_arns"`
+	EventStatusCodes    []string            `yaml:"event_status_codes"`
+	EventTypeCategories []string            `yaml:"event_type_categories"`
+	EventTypeCodes      []string            `yaml:"event_type_codes"`
+	LastUpdatedTimes    []timeRange         `yaml:"last_updated_times"`
+	Regions             []string            `yaml:"regions"`
+	Services            []string            `yaml:"services"`
+	StartTimes          []timeRange         `yaml:"start_times"`
+	Tags                []map[string]string `yaml:"tags"`
+}
+
+// accountConfig is an account whose events are fetched with an assumed role
+type accountConfig struct {
+	// ID defaults to the account of RoleARN, Name to the ID
+	ID          string `yaml:"id"`
+	Name        string `yaml:"name"`
+	RoleARN     string `yaml:"role_arn"`
+	ExternalID  string `yaml:"external_id"`
+	SessionName string `yaml:"session_name"`
+}
+
+type timeRange struct {
+	From *time.Time `yaml:"from"`
+	To   *time.Time `yaml:"to"`
+}
+
+// job polls the events of a filter on its own schedule. Without
+// --config.file there is a single job with an empty name for the flags.
+type job struct {
+	name     string
+	filter   *health.EventFilter
+	interval time.Duration
+	// labels are the extra labels of aws_health_events
+	labels map[string]string
+
+	// last is the last successful snapshot of the job and failed whether
+	// the last poll failed, both guarded by exporter.mu
+	last   *snapshot
+	failed bool
+	// stop ends the poll of the job when it is replaced on reload
+	stop chan struct{}
+}
+
+// reservedLabels can't be used as extra labels of a job
+var reservedLabels = map[string]bool{
+	LabelCategory:    true,
+	LabelRegion:      true,
+	LabelService:     true,
+	LabelStatusCode:  true,
+	LabelAccountID:   true,
+	LabelAccountName: true,
+	LabelFilter:      true,
+}
+
+// loadConfig reads and validates the YAML file at path
+func loadConfig(path string) (*config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &config{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if len(c.Jobs) == 0 && len(c.Accounts) == 0 {
+		return nil, fmt.Errorf("invalid config file %s: neither jobs nor accounts", path)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *config) validate() error {
+	names := map[string]bool{}
+	for _, jc := range c.Jobs {
+		if jc.Name == "" {
+			return fmt.Errorf("job without name")
+		}
+		if names[jc.Name] {
+			return fmt.Errorf("duplicate job %s", jc.Name)
+		}
+		names[jc.Name] = true
+
+		for name := range jc.Labels {
+			if !model.LabelName(name).IsValid() || reservedLabels[name] {
+				return fmt.Errorf("invalid label %q of job %s", name, jc.Name)
+			}
+		}
+	}
+
+	ids := map[string]bool{}
+	for _, ac := range c.Accounts {
+		if ac.RoleARN == "" {
+			return fmt.Errorf("account %s without role_arn", ac.ID)
+		}
+		if ac.ID == "" {
+			// arn:aws:iam::123456789012:role/name
+			parts := strings.SplitN(ac.RoleARN, ":", 6)
+			if len(parts) != 6 || parts[4] == "" {
+				return fmt.Errorf("invalid role_arn %s", ac.RoleARN)
+			}
+			ac.ID = parts[4]
+		}
+		if ac.Name == "" {
+			ac.Name = ac.ID
+		}
+		if ids[ac.ID] {
+			return fmt.Errorf("duplicate account %s", ac.ID)
+		}
+		ids[ac.ID] = true
+	}
+	return nil
+}
+
+// jobs returns the jobs of the config, interval is the poll interval of
+// jobs without their own
+func (c *config) jobs(interval time.Duration) []*job {
+	var jobs []*job
+	for _, jc := range c.Jobs {
+		j := &job{name: jc.Name, filter: jc.Filter.eventFilter(), interval: jc.PollInterval, labels: jc.Labels}
+		if j.interval <= 0 {
+			j.interval = interval
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+func (f *filterConfig) eventFilter() *health.EventFilter {
+	filter := &health.EventFilter{
+		EndTimes:         timeRanges(f.EndTimes),
+		LastUpdatedTimes: timeRanges(f.LastUpdatedTimes),
+		StartTimes:       timeRanges(f.StartTimes),
+	}
+	if len(f.AvailabilityZones) > 0 {
+		filter.AvailabilityZones = aws.StringSlice(f.AvailabilityZones)
+	}
+	if len(f.EntityArns) > 0 {
+		filter.EntityArns = aws.StringSlice(f.EntityArns)
+	}
+	if len(f.EntityValues) > 0 {
+		filter.EntityValues = aws.StringSlice(f.EntityValues)
+	}
+	if len(f.EventArns) > 0 {
+		filter.EventArns = aws.StringSlice(f.EventArns)
+	}
+	if len(f.EventStatusCodes) > 0 {
+		filter.EventStatusCodes = aws.StringSlice(f.EventStatusCodes)
+	}
+	if len(f.EventTypeCategories) > 0 {
+		filter.EventTypeCategories = aws.StringSlice(f.EventTypeCategories)
+	}
+	if len(f.EventTypeCodes) > 0 {
+		filter.EventTypeCodes = aws.StringSlice(f.EventTypeCodes)
+	}
+	if len(f.Regions) > 0 {
+		filter.Regions = aws.StringSlice(f.Regions)
+	}
+	if len(f.Services) > 0 {
+		filter.Services = aws.StringSlice(f.Services)
+	}
+	for _, tags := range f.Tags {
+		filter.Tags = append(filter.Tags, aws.StringMap(tags))
+	}
+	return filter
+}
+
+func timeRanges(ranges []timeRange) []*health.DateTimeRange {
+	var r []*health.DateTimeRange
+	for _, tr := range ranges {
+		r = append(r, &health.DateTimeRange{From: tr.From, To: tr.To})
+	}
+	return r
+}
+
+// jobLabels returns the labels that are added to aws_health_events for
+// jobs, the filter label and the union of the extra labels of all jobs.
+// A single job from the flags adds no labels.
+func jobLabels(jobs []*job) []string {
+	if len(jobs) == 0 || jobs[0].name == "" {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var extra []string
+	for _, j := range jobs {
+		for name := range j.labels {
+			if !seen[name] {
+				seen[name] = true
+				extra = append(extra, name)
+			}
+		}
+	}
+	sort.Strings(extra)
+	return append([]string{LabelFilter}, extra...)
+}
+
+// labelValues returns the values of names for j, jobs without one of the
+// extra labels get an empty value
+func (j *job) labelValues(names []string) []string {
+	var values []string
+	for _, name := range names {
+		if name == LabelFilter {
+			values = append(values, j.name)
+			continue
+		}
+		values = append(values, j.labels[name])
+	}
+	return values
+}
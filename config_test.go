@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+jobs:
+  - name: our-regions
+    labels:
+      team: platform
+    filter:
+      event_type_categories: [issue]
+      regions: [eu-west-1, eu-central-1]
+      start_times:
+        - from: 2023-01-01T00:00:00Z
+  - name: scheduled
+    poll_interval: 10m
+    filter:
+      event_type_categories: [scheduledChange]
+      services: [EC2, RDS]
+`)
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := c.jobs(time.Minute)
+	if len(jobs) != 2 {
+		t.Fatalf("Expected 2 jobs, got %d", len(jobs))
+	}
+	if jobs[0].interval != time.Minute || jobs[1].interval != 10*time.Minute {
+		t.Errorf("Unexpected intervals %s, %s", jobs[0].interval, jobs[1].interval)
+	}
+	f := jobs[0].filter
+	if len(f.Regions) != 2 || aws.StringValue(f.EventTypeCategories[0]) != "issue" || f.Services != nil {
+		t.Errorf("Unexpected filter %v", f)
+	}
+	if len(f.StartTimes) != 1 || !aws.TimeValue(f.StartTimes[0].From).Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected start times %v", f.StartTimes)
+	}
+
+	names := jobLabels(jobs)
+	if strings.Join(names, ",") != "filter,team" {
+		t.Errorf("Unexpected job labels %v", names)
+	}
+	if v := jobs[1].labelValues(names); strings.Join(v, ",") != "scheduled," {
+		t.Errorf("Unexpected label values %v", v)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, content := range []string{
+		"jobs: []",
+		"jobs: [{filter: {regions: [eu-west-1]}}]",
+		"jobs: [{name: a}, {name: a}]",
+		"jobs: [{name: a, labels: {region: eu}}]",
+		"jobs: [{name: a, labels: {team-name: a}}]",
+		"jobs: [{name: a, filter: {region: [eu-west-1]}}]",
+		"accounts: [{id: '123456789012'}]",
+		"accounts: [{role_arn: 'arn:aws:iam::123456789012:role/a'}, {role_arn: 'arn:aws:iam::123456789012:role/b'}]",
+		"accounts: [{role_arn: invalid}]",
+	} {
+		if _, err := loadConfig(writeConfig(t, content)); err == nil {
+			t.Errorf("Expected error for %q", content)
+		}
+	}
+}
+
+func TestLoadConfigAccounts(t *testing.T) {
+	c, err := loadConfig(writeConfig(t, `
+accounts:
+  - role_arn: arn:aws:iam::123456789012:role/health-exporter
+  - name: production
+    role_arn: arn:aws:iam::210987654321:role/health-exporter
+    external_id: secret
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Jobs) != 0 || len(c.Accounts) != 2 {
+		t.Fatalf("Unexpected config %v", c)
+	}
+	if a := c.Accounts[0]; a.ID != "123456789012" || a.Name != "123456789012" {
+		t.Errorf("Expected ID and name from the role ARN, got %v", a)
+	}
+	if a := c.Accounts[1]; a.ID != "210987654321" || a.Name != "production" || a.ExternalID != "secret" {
+		t.Errorf("Unexpected account %v", a)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	ec2 := &health.Event{Arn: aws.String("arn:event/ec2"), EventTypeCategory: aws.String("issue"), Region: aws.String("eu-west-1"), Service: aws.String("EC2"), StatusCode: aws.String("open")}
+	rds := &health.Event{Arn: aws.String("arn:event/rds"), EventTypeCategory: aws.String("issue"), Region: aws.String("us-east-1"), Service: aws.String("RDS"), StatusCode: aws.String("open")}
+	api := &mockHealthAPI{events: []*health.Event{ec2, rds}}
+
+	e := &exporter{api: api, jobs: []*job{
+		{name: "europe", filter: &health.EventFilter{Regions: aws.StringSlice([]string{"eu-west-1"})}, labels: map[string]string{"team": "platform"}},
+		{name: "all", filter: &health.EventFilter{}},
+	}}
+
+	e.refresh(context.Background(), e.jobs[0])
+	if snap := e.snapshot(); len(snap.events) != 1 {
+		t.Errorf("Expected 1 event after the first job, got %d", len(snap.events))
+	}
+	e.refresh(context.Background(), e.jobs[1])
+	if snap := e.snapshot(); len(snap.events) != 2 {
+		t.Errorf("Expected 2 deduplicated events, got %d", len(snap.events))
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	e.Collect(ch)
+	close(ch)
+	counts := map[string]float64{}
+	for m := range ch {
+		pb := &dto.Metric{}
+		m.Write(pb)
+		if pb.Gauge == nil || len(pb.Label) != 6 {
+			continue
+		}
+		var key []string
+		for _, l := range pb.Label {
+			if l.GetName() == LabelFilter || l.GetName() == "team" || l.GetName() == LabelService {
+				key = append(key, l.GetValue())
+			}
+		}
+		counts[strings.Join(key, "/")] = pb.Gauge.GetValue()
+	}
+
+	expected := map[string]float64{"europe/EC2/platform": 1, "all/EC2/": 1, "all/RDS/": 1}
+	if len(counts) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, counts)
+	}
+	for k, v := range expected {
+		if counts[k] != v {
+			t.Errorf("Expected %v for %s, got %v", v, k, counts[k])
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+// recentlyClosed is how long closed events are listed on the dashboard
+const recentlyClosed = 7 * 24 * time.Hour
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"formatTime": formatTime,
+}).ParseFS(templateFiles, "templates/*.html"))
+
+// dashboard serves the HTML pages on / and /events/{arn}
+type dashboard struct {
+	api *eventsAPI
+}
+
+// section is a list of events on the dashboard grouped by service and region
+type section struct {
+	Title  string
+	Groups []*group
+}
+
+type group struct {
+	Service string
+	Region  string
+	Events  []apiEvent
+}
+
+type indexPage struct {
+	Title    string
+	Updated  time.Time
+	Sections []*section
+}
+
+type eventPage struct {
+	Title   string
+	Updated time.Time
+	Event   apiEvent
+}
+
+// index lists open issues, upcoming scheduled changes, open account
+// notifications and recently closed events
+func (d *dashboard) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var (
+		issues        = &section{Title: "Open issues"}
+		scheduled     = &section{Title: "Upcoming scheduled changes"}
+		notifications = &section{Title: "Open account notifications"}
+		closed        = &section{Title: "Recently closed"}
+		page          = &indexPage{Title: "Dashboard", Sections: []*section{issues, scheduled, notifications, closed}}
+	)
+
+	if snap := d.api.exporter.snapshot(); snap != nil {
+		page.Updated = snap.timestamp
+		for _, e := range snap.events {
+			var s *section
+			switch {
+			case !isActive(e):
+				if e.LastUpdatedTime == nil || time.Since(*e.LastUpdatedTime) > recentlyClosed {
+					continue
+				}
+				s = closed
+			case aws.StringValue(e.EventTypeCategory) == health.EventTypeCategoryScheduledChange:
+				s = scheduled
+			case aws.StringValue(e.EventTypeCategory) == health.EventTypeCategoryAccountNotification:
+				s = notifications
+			default:
+				s = issues
+			}
+			s.add(d.api.event(snap, e))
+		}
+	}
+	for _, s := range page.Sections {
+		s.sort()
+	}
+
+	d.render(w, "index.html", page)
+}
+
+// event shows a single event with description and affected entities
+func (d *dashboard) event(w http.ResponseWriter, r *http.Request) {
+	snap := d.api.exporter.snapshot()
+	if snap == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	e := snap.find(strings.TrimPrefix(r.URL.Path, "/events/"))
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	event := d.api.event(snap, e)
+	event.Entities = d.api.entities(event.Arn)
+
+	d.render(w, "event.html", &eventPage{Title: event.EventTypeCode, Updated: snap.timestamp, Event: event})
+}
+
+func (d *dashboard) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *section) add(e apiEvent) {
+	for _, g := range s.Groups {
+		if g.Service == e.Service && g.Region == e.Region {
+			g.Events = append(g.Events, e)
+			return
+		}
+	}
+	s.Groups = append(s.Groups, &group{Service: e.Service, Region: e.Region, Events: []apiEvent{e}})
+}
+
+// sort orders the groups by service and region and the events by start
+// time, latest first
+func (s *section) sort() {
+	sort.Slice(s.Groups, func(i, j int) bool {
+		if s.Groups[i].Service != s.Groups[j].Service {
+			return s.Groups[i].Service < s.Groups[j].Service
+		}
+		return s.Groups[i].Region < s.Groups[j].Region
+	})
+	for _, g := range s.Groups {
+		events := g.Events
+		sort.Slice(events, func(i, j int) bool {
+			return aws.TimeValue(events[i].StartTime).After(aws.TimeValue(events[j].StartTime))
+		})
+	}
+}
+
+// formatTime formats time.Time and *time.Time values for the templates
+func formatTime(v interface{}) string {
+	var t time.Time
+	switch v := v.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		t = aws.TimeValue(v)
+	}
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
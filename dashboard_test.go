@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestDashboardIndex(t *testing.T) {
+	a := newTestEventsAPI(t)
+	// the closed LAMBDA event is recent, the EC2 event is open
+	a.exporter.snapshot().events[1].LastUpdatedTime = aws.Time(time.Now())
+	d := &dashboard{api: a}
+
+	w := httptest.NewRecorder()
+	d.index(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+
+	for _, want := range []string{"Open issues", "AWS_EC2_OPERATIONAL_ISSUE", "EC2 eu-west-1", "Recently closed", "LAMBDA us-east-1", `href="/events/` + testARN + `"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the dashboard to contain %q", want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	d.index(w, httptest.NewRequest("GET", "/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestDashboardEvent(t *testing.T) {
+	d := &dashboard{api: newTestEventsAPI(t)}
+
+	w := httptest.NewRecorder()
+	d.event(w, httptest.NewRequest("GET", "/events/"+testARN, nil))
+	body := w.Body.String()
+
+	for _, want := range []string{"We are investigating increased error rates.", "i-1", "IMPAIRED", "2023-06-01 10:00 UTC"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the event page to contain %q", want)
+		}
+	}
+}
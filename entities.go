@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/aws/aws-sdk-go/service/health/healthiface"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// LabelEntityStatusCode defines the status of an affected entity, e.g. IMPAIRED, UNIMPAIRED, UNKNOWN, PENDING, RESOLVED
+	LabelEntityStatusCode = "entity_status_code"
+	// LabelEntityValue defines the value of an affected entity, e.g. an EC2 instance ID
+	LabelEntityValue = "entity_value"
+	// LabelEntityARN defines the ARN of an affected entity
+	LabelEntityARN = "entity_arn"
+
+	// maxEventARNs is the maximum number of event ARNs or organization entity
+	// filters per DescribeAffectedEntities(ForOrganization) call
+	maxEventARNs = 10
+)
+
+var (
+	// eventEntitiesDesc is the number of affected entities per event and entity status
+	eventEntitiesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "event_entities"),
+		"Number of entities affected by an aws health event by account and entity status",
+		[]string{LabelARN, LabelAccountID, LabelEntityStatusCode},
+		nil,
+	)
+
+	// affectedEntityInfoDesc is an info metric with one series per affected entity
+	affectedEntityInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "affected_entity_info"),
+		"Info metric with one series per entity affected by an aws health event",
+		[]string{LabelARN, LabelAccountID, LabelEntityARN, LabelEntityValue, LabelEntityStatusCode},
+		nil,
+	)
+)
+
+// affectedEntities fetches and caches the entities affected by open and
+// upcoming events. Entities are refetched when they are older than ttl.
+type affectedEntities struct {
+	api healthiface.HealthAPI
+	ttl time.Duration
+
+	// perEntity enables aws_health_affected_entity_info
+	perEntity bool
+	// organization fetches the entities of all affected accounts
+	organization bool
+
+	mu    sync.RWMutex
+	cache map[string]*entitiesEntry
+}
+
+type entitiesEntry struct {
+	entities []*health.AffectedEntity
+	fetched  time.Time
+}
+
+func newAffectedEntities(api healthiface.HealthAPI, ttl time.Duration, perEntity, organization bool) *affectedEntities {
+	return &affectedEntities{
+		api:          api,
+		ttl:          ttl,
+		perEntity:    perEntity,
+		organization: organization,
+		cache:        map[string]*entitiesEntry{},
+	}
+}
+
+// refresh fetches the entities of all open and upcoming events whose cache
+// entry is missing or expired. Entries of other events are dropped.
+func (a *affectedEntities) refresh(ctx context.Context, snap *snapshot) error {
+	now := time.Now()
+	active := map[string]bool{}
+	var stale []string
+
+	a.mu.RLock()
+	for _, e := range snap.events {
+		if !isActive(e) {
+			continue
+		}
+		arn := aws.StringValue(e.Arn)
+		active[arn] = true
+		if entry, ok := a.cache[arn]; !ok || now.Sub(entry.fetched) > a.ttl {
+			stale = append(stale, arn)
+		}
+	}
+	a.mu.RUnlock()
+
+	var (
+		fetched = map[string]*entitiesEntry{}
+		failed  = map[string]bool{}
+		err     error
+	)
+	for _, arn := range stale {
+		fetched[arn] = &entitiesEntry{fetched: now}
+	}
+	var failedSet []failedItem
+	for _, batch := range a.batches(stale, snap.accounts) {
+		entities, items, ferr := a.fetch(ctx, batch)
+		if ferr != nil {
+			err = ferr
+			for _, f := range batch {
+				failed[aws.StringValue(f.EventArn)] = true
+			}
+			continue
+		}
+		// the entities of a partially failed event would be incomplete
+		for _, item := range items {
+			failed[item.arn] = true
+		}
+		failedSet = append(failedSet, items...)
+		for _, ent := range entities {
+			if entry, ok := fetched[aws.StringValue(ent.EventArn)]; ok {
+				entry.entities = append(entry.entities, ent)
+			}
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for arn := range a.cache {
+		if !active[arn] {
+			delete(a.cache, arn)
+		}
+	}
+	for arn, entry := range fetched {
+		if !failed[arn] {
+			a.cache[arn] = entry
+		}
+	}
+
+	return errors.Join(err, failedSetError("DescribeAffectedEntitiesForOrganization", failedSet))
+}
+
+// batches splits the given events into batches for fetch. In organization
+// mode there is one filter per event and affected account.
+func (a *affectedEntities) batches(arns []string, accounts map[string][]string) [][]*health.EventAccountFilter {
+	var filters []*health.EventAccountFilter
+	for _, arn := range arns {
+		if !a.organization || len(accounts[arn]) == 0 {
+			filters = append(filters, &health.EventAccountFilter{EventArn: aws.String(arn)})
+			continue
+		}
+		for _, id := range accounts[arn] {
+			filters = append(filters, &health.EventAccountFilter{EventArn: aws.String(arn), AwsAccountId: aws.String(id)})
+		}
+	}
+
+	var batches [][]*health.EventAccountFilter
+	for len(filters) > maxEventARNs {
+		batches = append(batches, filters[:maxEventARNs])
+		filters = filters[maxEventARNs:]
+	}
+	if len(filters) > 0 {
+		batches = append(batches, filters)
+	}
+	return batches
+}
+
+// fetch returns the entities of the given events and in organization mode
+// the events whose entities could not be described for an account
+func (a *affectedEntities) fetch(ctx context.Context, filters []*health.EventAccountFilter) ([]*health.AffectedEntity, []failedItem, error) {
+	var entities []*health.AffectedEntity
+
+	if a.organization {
+		var failed []failedItem
+		err := a.api.DescribeAffectedEntitiesForOrganizationPagesWithContext(ctx, &health.DescribeAffectedEntitiesForOrganizationInput{
+			OrganizationEntityFilters: filters,
+		}, func(out *health.DescribeAffectedEntitiesForOrganizationOutput, lastPage bool) bool {
+			entities = append(entities, out.Entities...)
+			for _, f := range out.FailedSet {
+				failed = append(failed, failedItem{
+					arn:     aws.StringValue(f.EventArn),
+					account: aws.StringValue(f.AwsAccountId),
+					code:    aws.StringValue(f.ErrorName),
+					message: aws.StringValue(f.ErrorMessage),
+				})
+			}
+			return true
+		})
+		return entities, failed, err
+	}
+
+	var arns []*string
+	for _, f := range filters {
+		arns = append(arns, f.EventArn)
+	}
+	err := a.api.DescribeAffectedEntitiesPagesWithContext(ctx, &health.DescribeAffectedEntitiesInput{
+		Filter: &health.EntityFilter{EventArns: arns},
+	}, func(out *health.DescribeAffectedEntitiesOutput, lastPage bool) bool {
+		entities = append(entities, out.Entities...)
+		return true
+	})
+
+	return entities, nil, err
+}
+
+// get returns the cached entities of the event with the given ARN
+func (a *affectedEntities) get(arn string) ([]*health.AffectedEntity, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	entry, ok := a.cache[arn]
+	if !ok {
+		return nil, false
+	}
+	return entry.entities, true
+}
+
+func (a *affectedEntities) Describe(ch chan<- *prometheus.Desc) {
+	ch <- eventEntitiesDesc
+	if a.perEntity {
+		ch <- affectedEntityInfoDesc
+	}
+}
+
+func (a *affectedEntities) Collect(ch chan<- prometheus.Metric) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	type key struct{ account, status string }
+
+	for arn, entry := range a.cache {
+		counts := map[key]int{}
+		for _, ent := range entry.entities {
+			k := key{aws.StringValue(ent.AwsAccountId), aws.StringValue(ent.StatusCode)}
+			counts[k]++
+
+			if a.perEntity {
+				ch <- prometheus.MustNewConstMetric(affectedEntityInfoDesc, prometheus.GaugeValue, 1,
+					arn,
+					k.account,
+					aws.StringValue(ent.EntityArn),
+					aws.StringValue(ent.EntityValue),
+					k.status)
+			}
+		}
+		for k, n := range counts {
+			ch <- prometheus.MustNewConstMetric(eventEntitiesDesc, prometheus.GaugeValue, float64(n), arn, k.account, k.status)
+		}
+	}
+}
+
+// isActive returns true for open and upcoming events
+func isActive(e *health.Event) bool {
+	switch aws.StringValue(e.StatusCode) {
+	case health.EventStatusCodeOpen, health.EventStatusCodeUpcoming:
+		return true
+	}
+	return false
+}
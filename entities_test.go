@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+func TestAffectedEntitiesRefresh(t *testing.T) {
+	var events []*health.Event
+	for i := 0; i < 12; i++ {
+		events = append(events, &health.Event{
+			Arn:        aws.String(fmt.Sprintf("arn:event/%d", i)),
+			StatusCode: aws.String("open"),
+		})
+	}
+	events = append(events, &health.Event{
+		Arn:        aws.String("arn:event/closed"),
+		StatusCode: aws.String("closed"),
+	})
+
+	api := &mockHealthAPI{entities: []*health.AffectedEntity{
+		&health.AffectedEntity{EventArn: aws.String("arn:event/0"), EntityValue: aws.String("i-1"), StatusCode: aws.String("IMPAIRED")},
+		&health.AffectedEntity{EventArn: aws.String("arn:event/0"), EntityValue: aws.String("i-2"), StatusCode: aws.String("IMPAIRED")},
+		&health.AffectedEntity{EventArn: aws.String("arn:event/11"), EntityValue: aws.String("i-3"), StatusCode: aws.String("RESOLVED")},
+		&health.AffectedEntity{EventArn: aws.String("arn:event/closed"), EntityValue: aws.String("i-4"), StatusCode: aws.String("RESOLVED")},
+	}}
+	a := newAffectedEntities(api, time.Hour, false, false)
+
+	if err := a.refresh(context.Background(), &snapshot{events: events}); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.calls["DescribeAffectedEntities"]; n != 2 {
+		t.Errorf("Expected 2 batched calls, got %d", n)
+	}
+	if entities, _ := a.get("arn:event/0"); len(entities) != 2 {
+		t.Errorf("Expected 2 entities, got %d", len(entities))
+	}
+	if _, ok := a.get("arn:event/closed"); ok {
+		t.Errorf("Expected no entities for closed events")
+	}
+
+	// entity counts for arn:event/0 and arn:event/11
+	if n := collectCount(a); n != 2 {
+		t.Errorf("Expected 2 metrics, got %d", n)
+	}
+	a.perEntity = true
+	if n := collectCount(a); n != 5 {
+		t.Errorf("Expected 5 metrics with per-entity series, got %d", n)
+	}
+
+	// cached entries are not fetched again and dropped once the event is gone
+	if err := a.refresh(context.Background(), &snapshot{events: events[:1]}); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.calls["DescribeAffectedEntities"]; n != 2 {
+		t.Errorf("Expected cached entities to be reused, got %d calls", n)
+	}
+	if _, ok := a.get("arn:event/11"); ok {
+		t.Errorf("Expected entities of removed events to be dropped")
+	}
+}
+
+func TestAffectedEntitiesBatches(t *testing.T) {
+	a := newAffectedEntities(nil, time.Hour, false, true)
+	arns := []string{"arn:event/0", "arn:event/1"}
+	accounts := map[string][]string{"arn:event/0": {"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"}}
+
+	batches := a.batches(arns, accounts)
+	if len(batches) != 2 || len(batches[0]) != maxEventARNs || len(batches[1]) != 2 {
+		t.Fatalf("Invalid batches: %v", batches)
+	}
+	if f := batches[1][1]; aws.StringValue(f.EventArn) != "arn:event/1" || f.AwsAccountId != nil {
+		t.Errorf("Expected a filter without account for events without accounts, got %v", f)
+	}
+}
+
+// partialEntitiesAPI fails to describe the entities of arn:event/1 for
+// account 2
+type partialEntitiesAPI struct {
+	mockHealthAPI
+}
+
+func (api *partialEntitiesAPI) DescribeAffectedEntitiesForOrganizationPagesWithContext(ctx aws.Context, in *health.DescribeAffectedEntitiesForOrganizationInput, fn func(*health.DescribeAffectedEntitiesForOrganizationOutput, bool) bool, opts ...request.Option) error {
+	out := &health.DescribeAffectedEntitiesForOrganizationOutput{}
+	for _, f := range in.OrganizationEntityFilters {
+		if aws.StringValue(f.EventArn) == "arn:event/1" && aws.StringValue(f.AwsAccountId) == "2" {
+			out.FailedSet = append(out.FailedSet, &health.OrganizationAffectedEntitiesErrorItem{
+				EventArn:     f.EventArn,
+				AwsAccountId: f.AwsAccountId,
+				ErrorName:    aws.String("AccessDenied"),
+				ErrorMessage: aws.String("Access denied"),
+			})
+			continue
+		}
+		out.Entities = append(out.Entities, &health.AffectedEntity{EventArn: f.EventArn, AwsAccountId: f.AwsAccountId})
+	}
+	fn(out, true)
+	return nil
+}
+
+func TestAffectedEntitiesFailedSet(t *testing.T) {
+	a := newAffectedEntities(&partialEntitiesAPI{}, time.Hour, false, true)
+	snap := &snapshot{
+		events: []*health.Event{
+			{Arn: aws.String("arn:event/0"), StatusCode: aws.String("open")},
+			{Arn: aws.String("arn:event/1"), StatusCode: aws.String("open")},
+		},
+		accounts: map[string][]string{"arn:event/0": {"1"}, "arn:event/1": {"1", "2"}},
+	}
+	failures := apiErrors.WithLabelValues("DescribeAffectedEntitiesForOrganization", "AccessDenied")
+	before := metricValue(failures)
+
+	err := a.refresh(context.Background(), snap)
+	if err == nil || !strings.Contains(err.Error(), "arn:event/1 of account 2") {
+		t.Errorf("Expected the failed event in the error, got %v", err)
+	}
+	if v := metricValue(failures) - before; v != 1 {
+		t.Errorf("Expected 1 api error, got %v", v)
+	}
+	if _, ok := a.get("arn:event/0"); !ok {
+		t.Errorf("Expected the entities of the successful event")
+	}
+	if _, ok := a.get("arn:event/1"); ok {
+		t.Errorf("Expected no incomplete entities for the failed event")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/aws/aws-sdk-go/service/health/healthiface"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxAggregateEventARNs is the maximum number of event ARNs per DescribeEntityAggregates call
+const maxAggregateEventARNs = 50
+
+// eventAffectedEntitiesDesc is the number of affected entities per event
+var eventAffectedEntitiesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(Namespace, "", "event_affected_entities"),
+	"Number of entities affected by an open or upcoming aws health event",
+	[]string{LabelARN, LabelEventTypeCode},
+	nil,
+)
+
+// entityAggregates counts the entities affected by open and upcoming events
+// with DescribeEntityAggregates. It is a cheap alternative to fetching every
+// entity with affectedEntities and refreshed on its own schedule, see run.
+type entityAggregates struct {
+	api healthiface.HealthAPI
+
+	mu     sync.RWMutex
+	counts map[string]*entityCount
+}
+
+type entityCount struct {
+	eventTypeCode string
+	count         float64
+}
+
+func newEntityAggregates(api healthiface.HealthAPI) *entityAggregates {
+	return &entityAggregates{api: api, counts: map[string]*entityCount{}}
+}
+
+// run refreshes the counts from the snapshot of e every interval until
+// ctx is done
+func (a *entityAggregates) run(ctx context.Context, e *exporter, interval time.Duration) {
+	for {
+		// wait for the first poll
+		wait := time.Second
+		if snap := e.snapshot(); snap != nil {
+			if err := a.refresh(ctx, snap); err != nil {
+				log.Println(err)
+			}
+			wait = interval
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh replaces the counts with the entity aggregates of the open and
+// upcoming events of snap. On error the previous counts are kept.
+func (a *entityAggregates) refresh(ctx context.Context, snap *snapshot) error {
+	typeCodes := map[string]string{}
+	var arns []string
+	for _, e := range snap.events {
+		if !isActive(e) {
+			continue
+		}
+		arn := aws.StringValue(e.Arn)
+		typeCodes[arn] = aws.StringValue(e.EventTypeCode)
+		arns = append(arns, arn)
+	}
+
+	counts := map[string]*entityCount{}
+	for len(arns) > 0 {
+		n := len(arns)
+		if n > maxAggregateEventARNs {
+			n = maxAggregateEventARNs
+		}
+
+		out, err := a.api.DescribeEntityAggregatesWithContext(ctx, &health.DescribeEntityAggregatesInput{
+			EventArns: aws.StringSlice(arns[:n]),
+		})
+		if err != nil {
+			return err
+		}
+		for _, agg := range out.EntityAggregates {
+			arn := aws.StringValue(agg.EventArn)
+			counts[arn] = &entityCount{
+				eventTypeCode: typeCodes[arn],
+				count:         float64(aws.Int64Value(agg.Count)),
+			}
+		}
+		arns = arns[n:]
+	}
+
+	a.mu.Lock()
+	a.counts = counts
+	a.mu.Unlock()
+
+	return nil
+}
+
+func (a *entityAggregates) Describe(ch chan<- *prometheus.Desc) {
+	ch <- eventAffectedEntitiesDesc
+}
+
+func (a *entityAggregates) Collect(ch chan<- prometheus.Metric) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for arn, c := range a.counts {
+		ch <- prometheus.MustNewConstMetric(eventAffectedEntitiesDesc, prometheus.GaugeValue, c.count, arn, c.eventTypeCode)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+func TestEntityAggregatesRefresh(t *testing.T) {
+	var events []*health.Event
+	for i := 0; i < 60; i++ {
+		events = append(events, &health.Event{
+			Arn:           aws.String(fmt.Sprintf("arn:event/%d", i)),
+			EventTypeCode: aws.String("AWS_EC2_INSTANCE_RETIREMENT_SCHEDULED"),
+			StatusCode:    aws.String("upcoming"),
+		})
+	}
+	events = append(events, &health.Event{
+		Arn:        aws.String("arn:event/closed"),
+		StatusCode: aws.String("closed"),
+	})
+
+	api := &mockHealthAPI{entities: []*health.AffectedEntity{
+		&health.AffectedEntity{EventArn: aws.String("arn:event/0")},
+		&health.AffectedEntity{EventArn: aws.String("arn:event/0")},
+		&health.AffectedEntity{EventArn: aws.String("arn:event/closed")},
+	}}
+	a := newEntityAggregates(api)
+
+	if err := a.refresh(context.Background(), &snapshot{events: events}); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.calls["DescribeEntityAggregates"]; n != 2 {
+		t.Errorf("Expected 2 batched calls, got %d", n)
+	}
+	if c := a.counts["arn:event/0"]; c == nil || c.count != 2 || c.eventTypeCode != "AWS_EC2_INSTANCE_RETIREMENT_SCHEDULED" {
+		t.Errorf("Invalid count: %v", c)
+	}
+	if _, ok := a.counts["arn:event/closed"]; ok {
+		t.Errorf("Expected no count for closed events")
+	}
+	if n := collectCount(a); n != 60 {
+		t.Errorf("Expected 60 metrics, got %d", n)
+	}
+
+	api.err = errors.New("ThrottlingException")
+	if err := a.refresh(context.Background(), &snapshot{events: events}); err == nil {
+		t.Errorf("Expected an error")
+	}
+	if n := collectCount(a); n != 60 {
+		t.Errorf("Expected the previous counts to be kept, got %d metrics", n)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/aws/aws-sdk-go/service/health/healthiface"
+)
+
+// maxDetailARNs is the maximum number of events per DescribeEventDetails(ForOrganization) call
+const maxDetailARNs = 10
+
+// eventDetails fetches and caches the description and metadata of events.
+// An entry is fetched for open and upcoming events and fetched again
+// whenever the LastUpdatedTime of the event changes, so the final
+// description of an event that was just closed is fetched as well.
+type eventDetails struct {
+	api healthiface.HealthAPI
+	// organization fetches the details with DescribeEventDetailsForOrganization
+	organization bool
+
+	mu    sync.RWMutex
+	cache map[string]*detailsEntry
+}
+
+type detailsEntry struct {
+	lastUpdated time.Time
+	description string
+	metadata    map[string]string
+}
+
+func newEventDetails(api healthiface.HealthAPI, organization bool) *eventDetails {
+	return &eventDetails{
+		api:          api,
+		organization: organization,
+		cache:        map[string]*detailsEntry{},
+	}
+}
+
+// refresh fetches the details of new and updated events of snap. Entries of
+// events that are no longer part of the snapshot are dropped.
+func (d *eventDetails) refresh(ctx context.Context, snap *snapshot) error {
+	seen := map[string]bool{}
+	lastUpdated := map[string]time.Time{}
+	var stale []*health.EventAccountFilter
+
+	d.mu.RLock()
+	for _, e := range snap.events {
+		arn := aws.StringValue(e.Arn)
+		seen[arn] = true
+		lastUpdated[arn] = aws.TimeValue(e.LastUpdatedTime)
+
+		entry, ok := d.cache[arn]
+		if (!ok && isActive(e)) || (ok && !entry.lastUpdated.Equal(lastUpdated[arn])) {
+			f := &health.EventAccountFilter{EventArn: e.Arn}
+			if accounts := snap.accounts[arn]; len(accounts) > 0 {
+				// the description is the same for all affected accounts
+				f.AwsAccountId = aws.String(accounts[0])
+			}
+			stale = append(stale, f)
+		}
+	}
+	d.mu.RUnlock()
+
+	fetched := map[string]*detailsEntry{}
+	var err error
+	for len(stale) > 0 {
+		n := len(stale)
+		if n > maxDetailARNs {
+			n = maxDetailARNs
+		}
+		err = errors.Join(err, d.fetch(ctx, stale[:n], fetched))
+		stale = stale[n:]
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for arn := range d.cache {
+		if !seen[arn] {
+			delete(d.cache, arn)
+		}
+	}
+	for arn, entry := range fetched {
+		entry.lastUpdated = lastUpdated[arn]
+		d.cache[arn] = entry
+	}
+
+	return err
+}
+
+// fetch adds the details of the given events to fetched, the error lists
+// every event whose details could not be described
+func (d *eventDetails) fetch(ctx context.Context, filters []*health.EventAccountFilter, fetched map[string]*detailsEntry) error {
+	if d.organization {
+		out, err := d.api.DescribeEventDetailsForOrganizationWithContext(ctx, &health.DescribeEventDetailsForOrganizationInput{
+			OrganizationEventDetailFilters: filters,
+		})
+		if err != nil {
+			return err
+		}
+		for _, details := range out.SuccessfulSet {
+			fetched[aws.StringValue(details.Event.Arn)] = newDetailsEntry(details.EventDescription, details.EventMetadata)
+		}
+		var failed []failedItem
+		for _, f := range out.FailedSet {
+			failed = append(failed, failedItem{
+				arn:     aws.StringValue(f.EventArn),
+				account: aws.StringValue(f.AwsAccountId),
+				code:    aws.StringValue(f.ErrorName),
+				message: aws.StringValue(f.ErrorMessage),
+			})
+		}
+		return failedSetError("DescribeEventDetailsForOrganization", failed)
+	}
+
+	var arns []*string
+	for _, f := range filters {
+		arns = append(arns, f.EventArn)
+	}
+	out, err := d.api.DescribeEventDetailsWithContext(ctx, &health.DescribeEventDetailsInput{EventArns: arns})
+	if err != nil {
+		return err
+	}
+	for _, details := range out.SuccessfulSet {
+		fetched[aws.StringValue(details.Event.Arn)] = newDetailsEntry(details.EventDescription, details.EventMetadata)
+	}
+	var failed []failedItem
+	for _, f := range out.FailedSet {
+		failed = append(failed, failedItem{
+			arn:     aws.StringValue(f.EventArn),
+			code:    aws.StringValue(f.ErrorName),
+			message: aws.StringValue(f.ErrorMessage),
+		})
+	}
+	return failedSetError("DescribeEventDetails", failed)
+}
+
+func newDetailsEntry(desc *health.EventDescription, metadata map[string]*string) *detailsEntry {
+	entry := &detailsEntry{metadata: aws.StringValueMap(metadata)}
+	if desc != nil {
+		entry.description = aws.StringValue(desc.LatestDescription)
+	}
+	return entry
+}
+
+// description returns the latest description of the event with the given ARN
+func (d *eventDetails) description(arn string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	entry, ok := d.cache[arn]
+	if !ok {
+		return "", false
+	}
+	return entry.description, true
+}
+
+// metadata returns the metadata of the event with the given ARN
+func (d *eventDetails) metadata(arn string) map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if entry, ok := d.cache[arn]; ok {
+		return entry.metadata
+	}
+	return nil
+}
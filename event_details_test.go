@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+func TestEventDetailsRefresh(t *testing.T) {
+	updated := time.Unix(1700000000, 0)
+	var events []*health.Event
+	for i := 0; i < 11; i++ {
+		events = append(events, &health.Event{
+			Arn:             aws.String(fmt.Sprintf("arn:event/%d", i)),
+			StatusCode:      aws.String("open"),
+			LastUpdatedTime: aws.Time(updated),
+		})
+	}
+	closed := &health.Event{
+		Arn:             aws.String("arn:event/closed"),
+		StatusCode:      aws.String("closed"),
+		LastUpdatedTime: aws.Time(updated),
+	}
+	events = append(events, closed)
+
+	api := &mockHealthAPI{descriptions: map[string]string{"arn:event/0": "We are investigating increased error rates."}}
+	d := newEventDetails(api, false)
+
+	if err := d.refresh(context.Background(), &snapshot{events: events}); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.calls["DescribeEventDetails"]; n != 2 {
+		t.Errorf("Expected 2 batched calls, got %d", n)
+	}
+	if desc, _ := d.description("arn:event/0"); desc != "We are investigating increased error rates." {
+		t.Errorf("Invalid description: %q", desc)
+	}
+	if _, ok := d.description("arn:event/closed"); ok {
+		t.Errorf("Expected no details for events that were closed before")
+	}
+
+	// unchanged events are served from the cache
+	if err := d.refresh(context.Background(), &snapshot{events: events}); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.calls["DescribeEventDetails"]; n != 2 {
+		t.Errorf("Expected cached details, got %d calls", n)
+	}
+
+	// the final description of a closed event is fetched
+	api.descriptions["arn:event/0"] = "The issue has been resolved."
+	events[0].StatusCode = aws.String("closed")
+	events[0].LastUpdatedTime = aws.Time(updated.Add(time.Hour))
+	if err := d.refresh(context.Background(), &snapshot{events: events}); err != nil {
+		t.Fatal(err)
+	}
+	if desc, _ := d.description("arn:event/0"); desc != "The issue has been resolved." {
+		t.Errorf("Invalid description: %q", desc)
+	}
+
+	// entries are dropped with the event
+	if err := d.refresh(context.Background(), &snapshot{events: events[1:]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.description("arn:event/0"); ok {
+		t.Errorf("Expected the details of removed events to be dropped")
+	}
+}
+
+// partialDetailsAPI fails to describe the events without description
+type partialDetailsAPI struct {
+	mockHealthAPI
+}
+
+func (api *partialDetailsAPI) DescribeEventDetailsWithContext(ctx aws.Context, in *health.DescribeEventDetailsInput, opts ...request.Option) (*health.DescribeEventDetailsOutput, error) {
+	out := &health.DescribeEventDetailsOutput{}
+	for _, arn := range in.EventArns {
+		desc, ok := api.descriptions[aws.StringValue(arn)]
+		if !ok {
+			out.FailedSet = append(out.FailedSet, &health.EventDetailsErrorItem{
+				EventArn:     arn,
+				ErrorName:    aws.String("UnsupportedEventException"),
+				ErrorMessage: aws.String("Unsupported event"),
+			})
+			continue
+		}
+		out.SuccessfulSet = append(out.SuccessfulSet, &health.EventDetails{
+			Event:            &health.Event{Arn: arn},
+			EventDescription: &health.EventDescription{LatestDescription: aws.String(desc)},
+		})
+	}
+	return out, nil
+}
+
+func TestEventDetailsFailedSet(t *testing.T) {
+	api := &partialDetailsAPI{mockHealthAPI{descriptions: map[string]string{"arn:event/0": "Resolved."}}}
+	d := newEventDetails(api, false)
+	var events []*health.Event
+	for i := 0; i < 3; i++ {
+		events = append(events, &health.Event{Arn: aws.String(fmt.Sprintf("arn:event/%d", i)), StatusCode: aws.String("open")})
+	}
+	failures := apiErrors.WithLabelValues("DescribeEventDetails", "UnsupportedEventException")
+	before := metricValue(failures)
+
+	err := d.refresh(context.Background(), &snapshot{events: events})
+	if err == nil || !strings.Contains(err.Error(), "arn:event/1") || !strings.Contains(err.Error(), "arn:event/2") {
+		t.Errorf("Expected every failed event in the error, got %v", err)
+	}
+	if v := metricValue(failures) - before; v != 2 {
+		t.Errorf("Expected 2 api errors, got %v", v)
+	}
+	if desc, _ := d.description("arn:event/0"); desc != "Resolved." {
+		t.Errorf("Expected the description of the successful event, got %q", desc)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/aws/aws-sdk-go/service/health/healthiface"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventTypeInfoDesc is an info metric with one series per event type
+var eventTypeInfoDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(Namespace, "", "event_type_info"),
+	"Info metric with one series per aws health event type",
+	[]string{LabelService, LabelEventTypeCode, LabelCategory},
+	nil,
+)
+
+// eventType is the JSON representation of a health.EventType
+type eventType struct {
+	Service  string `json:"service"`
+	Code     string `json:"code"`
+	Category string `json:"category"`
+}
+
+// eventTypes is a cached catalog of all event types from DescribeEventTypes.
+// It is exported as metric and as JSON and used to validate the filters.
+type eventTypes struct {
+	api healthiface.HealthAPI
+
+	mu    sync.RWMutex
+	types []eventType
+}
+
+func newEventTypes(api healthiface.HealthAPI) *eventTypes {
+	return &eventTypes{api: api}
+}
+
+// run refreshes the catalog every interval until ctx is done
+func (c *eventTypes) run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+		if err := c.refresh(ctx); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// refresh replaces the catalog. On error the previous catalog is kept.
+func (c *eventTypes) refresh(ctx context.Context) error {
+	var types []eventType
+
+	err := c.api.DescribeEventTypesPagesWithContext(ctx, &health.DescribeEventTypesInput{}, func(out *health.DescribeEventTypesOutput, lastPage bool) bool {
+		for _, t := range out.EventTypes {
+			types = append(types, eventType{
+				Service:  aws.StringValue(t.Service),
+				Code:     aws.StringValue(t.Code),
+				Category: aws.StringValue(t.Category),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].Service != types[j].Service {
+			return types[i].Service < types[j].Service
+		}
+		return types[i].Code < types[j].Code
+	})
+
+	c.mu.Lock()
+	c.types = types
+	c.mu.Unlock()
+
+	return nil
+}
+
+// validate returns an error for services and event type codes of f that
+// are not in the catalog
+func (c *eventTypes) validate(f *health.EventFilter) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	services := map[string]bool{}
+	codes := map[string]bool{}
+	for _, t := range c.types {
+		services[t.Service] = true
+		codes[t.Code] = true
+	}
+
+	var unknown []string
+	for _, s := range aws.StringValueSlice(f.Services) {
+		if !services[s] {
+			unknown = append(unknown, "service "+s)
+		}
+	}
+	for _, code := range aws.StringValueSlice(f.EventTypeCodes) {
+		if !codes[code] {
+			unknown = append(unknown, "event type code "+code)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown %s in the event filter", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func (c *eventTypes) Describe(ch chan<- *prometheus.Desc) {
+	ch <- eventTypeInfoDesc
+}
+
+func (c *eventTypes) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, t := range c.types {
+		ch <- prometheus.MustNewConstMetric(eventTypeInfoDesc, prometheus.GaugeValue, 1, t.Service, t.Code, t.Category)
+	}
+}
+
+// ServeHTTP lists the event types as JSON, optionally filtered by the
+// service and category query parameters
+func (c *eventTypes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	category := r.URL.Query().Get("category")
+
+	c.mu.RLock()
+	types := []eventType{}
+	for _, t := range c.types {
+		if (service == "" || strings.EqualFold(t.Service, service)) && (category == "" || t.Category == category) {
+			types = append(types, t)
+		}
+	}
+	c.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, types)
+}
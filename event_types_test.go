@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+func newTestEventTypes(t *testing.T) *eventTypes {
+	api := &mockHealthAPI{eventTypes: []*health.EventType{
+		&health.EventType{Service: aws.String("RDS"), Code: aws.String("AWS_RDS_MAINTENANCE_SCHEDULED"), Category: aws.String("scheduledChange")},
+		&health.EventType{Service: aws.String("EC2"), Code: aws.String("AWS_EC2_INSTANCE_RETIREMENT_SCHEDULED"), Category: aws.String("scheduledChange")},
+		&health.EventType{Service: aws.String("EC2"), Code: aws.String("AWS_EC2_OPERATIONAL_ISSUE"), Category: aws.String("issue")},
+	}}
+	c := newEventTypes(api)
+	if err := c.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEventTypesValidate(t *testing.T) {
+	c := newTestEventTypes(t)
+
+	valid := &health.EventFilter{
+		Services:       aws.StringSlice([]string{"EC2"}),
+		EventTypeCodes: aws.StringSlice([]string{"AWS_RDS_MAINTENANCE_SCHEDULED"}),
+	}
+	if err := c.validate(valid); err != nil {
+		t.Errorf("Expected a valid filter, got %v", err)
+	}
+
+	invalid := &health.EventFilter{Services: aws.StringSlice([]string{"EC3"})}
+	if err := c.validate(invalid); err == nil {
+		t.Errorf("Expected an error for an unknown service")
+	}
+
+	if n := collectCount(c); n != 3 {
+		t.Errorf("Expected 3 metrics, got %d", n)
+	}
+}
+
+func TestEventTypesServeHTTP(t *testing.T) {
+	c := newTestEventTypes(t)
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/event-types?service=ec2", nil))
+
+	var types []eventType
+	if err := json.NewDecoder(w.Body).Decode(&types); err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 2 || types[0].Code != "AWS_EC2_INSTANCE_RETIREMENT_SCHEDULED" {
+		t.Errorf("Invalid event types: %v", types)
+	}
+}
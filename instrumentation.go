@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// LabelOperation defines the AWS API operation, e.g. DescribeEvents
+	LabelOperation = "operation"
+	// LabelErrorCode defines the AWS error code, e.g. ThrottlingException
+	LabelErrorCode = "code"
+)
+
+var (
+	// up is 1 if the last poll of the AWS Health API was successful
+	up = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "up",
+		Namespace: Namespace,
+		Help:      "Whether the last poll of the AWS Health API was successful",
+	})
+
+	// lastSuccess is the time of the last successful poll
+	lastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "last_success_timestamp_seconds",
+		Namespace: Namespace,
+		Help:      "Unix time of the last successful poll of the AWS Health API",
+	})
+
+	// scrapeDuration is the duration of the last poll of every job, the
+	// filter label is empty for the job from the flags
+	scrapeDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "scrape_duration_seconds",
+		Namespace: Namespace,
+		Help:      "Duration of the last poll of the AWS Health API",
+	}, []string{LabelFilter})
+
+	// scrapePages is the number of result pages fetched by all polls
+	scrapePages = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "scrape_pages_total",
+		Namespace: Namespace,
+		Help:      "Total number of result pages fetched from the AWS Health API",
+	})
+
+	// scrapeEvents is the number of events returned by all successful polls
+	scrapeEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "scrape_events_total",
+		Namespace: Namespace,
+		Help:      "Total number of events returned by successful polls of the AWS Health API",
+	})
+
+	// apiCalls is the number of AWS API calls per operation
+	apiCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "api_calls_total",
+		Namespace: Namespace,
+		Help:      "Total number of AWS API calls",
+	}, []string{LabelOperation})
+
+	// apiErrors is the number of failed AWS API calls per operation and error code
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "api_errors_total",
+		Namespace: Namespace,
+		Help:      "Total number of failed AWS API calls by error code",
+	}, []string{LabelOperation, LabelErrorCode})
+
+	// apiRetries is the number of retried AWS API calls per operation
+	apiRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "api_retries_total",
+		Namespace: Namespace,
+		Help:      "Total number of AWS API call retries",
+	}, []string{LabelOperation})
+
+	// apiDuration is the latency of AWS API calls including retries
+	apiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "api_call_duration_seconds",
+		Namespace: Namespace,
+		Help:      "Latency of AWS API calls including retries",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{LabelOperation})
+)
+
+func init() {
+	prometheus.MustRegister(up, lastSuccess, scrapeDuration, scrapePages, scrapeEvents,
+		apiCalls, apiErrors, apiRetries, apiDuration)
+}
+
+// instrumentHandlers adds a handler to the AWS SDK request handlers that
+// records the call, latency, retry and error metrics of every API call.
+func instrumentHandlers(h *request.Handlers) {
+	h.Complete.PushBackNamed(request.NamedHandler{
+		Name: "aws_health_exporter.instrument",
+		Fn:   observeRequest,
+	})
+}
+
+func observeRequest(r *request.Request) {
+	op := r.Operation.Name
+
+	apiCalls.WithLabelValues(op).Inc()
+	apiDuration.WithLabelValues(op).Observe(time.Since(r.Time).Seconds())
+	if r.RetryCount > 0 {
+		apiRetries.WithLabelValues(op).Add(float64(r.RetryCount))
+	}
+	if r.Error != nil {
+		apiErrors.WithLabelValues(op, errorCode(r.Error)).Inc()
+	}
+}
+
+// errorCode returns the AWS error code of err, e.g. ThrottlingException
+func errorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return "Unknown"
+}
+
+// failedItem is an entry of the FailedSet of the batch operations of the
+// AWS Health API, e.g. DescribeEventDetails. account is only set in
+// organization mode.
+type failedItem struct {
+	arn, account, code, message string
+}
+
+// failedSetError counts the failed items of op in aws_health_api_errors_total
+// and returns an error that lists every failed event, nil without items
+func failedSetError(op string, items []failedItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	var failed []string
+	for _, item := range items {
+		code := item.code
+		if code == "" {
+			code = "Unknown"
+		}
+		apiErrors.WithLabelValues(op, code).Inc()
+
+		f := item.arn
+		if item.account != "" {
+			f += " of account " + item.account
+		}
+		failed = append(failed, f+": "+item.message)
+	}
+	return fmt.Errorf("%s failed for %d events: %s", op, len(items), strings.Join(failed, ", "))
+}
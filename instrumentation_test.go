@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestObserveRequest(t *testing.T) {
+	op := "DescribeEventsTest"
+	r := &request.Request{
+		Operation:  &request.Operation{Name: op},
+		Time:       time.Now(),
+		RetryCount: 2,
+		Error:      awserr.New("ThrottlingException", "Rate exceeded", nil),
+	}
+	observeRequest(r)
+
+	if v := metricValue(apiCalls.WithLabelValues(op)); v != 1 {
+		t.Errorf("Invalid api calls - Expected: 1 Got: %v", v)
+	}
+	if v := metricValue(apiRetries.WithLabelValues(op)); v != 2 {
+		t.Errorf("Invalid api retries - Expected: 2 Got: %v", v)
+	}
+	if v := metricValue(apiErrors.WithLabelValues(op, "ThrottlingException")); v != 1 {
+		t.Errorf("Invalid api errors - Expected: 1 Got: %v", v)
+	}
+}
+
+func TestRefreshUp(t *testing.T) {
+	api := &mockHealthAPI{}
+	e := &exporter{api: api, jobs: []*job{{filter: &health.EventFilter{}}}}
+
+	e.refresh(context.Background(), e.jobs[0])
+	if v := metricValue(up); v != 1 {
+		t.Errorf("Invalid up - Expected: 1 Got: %v", v)
+	}
+
+	api.err = errors.New("SubscriptionRequiredException")
+	e.refresh(context.Background(), e.jobs[0])
+	if v := metricValue(up); v != 0 {
+		t.Errorf("Invalid up - Expected: 0 Got: %v", v)
+	}
+}
+
+func TestRefreshScrapeDuration(t *testing.T) {
+	e := &exporter{api: &mockHealthAPI{}, jobs: []*job{
+		{name: "europe", filter: &health.EventFilter{}},
+		{name: "rds", filter: &health.EventFilter{}},
+	}}
+	scrapeDuration.Reset()
+
+	for _, j := range e.jobs {
+		e.refresh(context.Background(), j)
+	}
+	ch := make(chan prometheus.Metric, 10)
+	scrapeDuration.Collect(ch)
+	if len(ch) != 2 {
+		t.Errorf("Expected the scrape duration of 2 jobs, got %d", len(ch))
+	}
+}
+
+func metricValue(m prometheus.Metric) float64 {
+	pb := &dto.Metric{}
+	m.Write(pb)
+	switch {
+	case pb.Gauge != nil:
+		return pb.GetGauge().GetValue()
+	case pb.Counter != nil:
+		return pb.GetCounter().GetValue()
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxDisappeared is the number of events closed by disappearing that are
+// kept for /api/v1/resolution-times
+const maxDisappeared = 1000
+
+var (
+	lifecycleLabels = []string{LabelCategory, LabelRegion, LabelService}
+
+	// eventsOpened is the number of events that were opened or announced
+	eventsOpened = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "events_opened_total",
+		Namespace: Namespace,
+		Help:      "Total number of aws health events that were opened or announced",
+	}, lifecycleLabels)
+
+	// eventsClosed is the number of events that were closed
+	eventsClosed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "events_closed_total",
+		Namespace: Namespace,
+		Help:      "Total number of aws health events that were closed",
+	}, lifecycleLabels)
+
+	// eventUpdates is the number of updates of events
+	eventUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "event_updates_total",
+		Namespace: Namespace,
+		Help:      "Total number of updates of aws health events",
+	}, lifecycleLabels)
+)
+
+func init() {
+	prometheus.MustRegister(eventsOpened, eventsClosed, eventUpdates)
+}
+
+// lifecycle counts the transitions between consecutive snapshots. Like
+// the notifications the first snapshot only sets the baseline.
+type lifecycle struct {
+	// aggregate counts events that disappear as closed, closed events are
+	// not listed in aggregate mode
+	aggregate bool
+	prev      map[string]*health.Event
+
+	// mu guards disappeared, the most recent events that were closed by
+	// disappearing in aggregate mode, oldest first
+	mu          sync.Mutex
+	disappeared []*health.Event
+}
+
+// reset makes the next snapshot the baseline, e.g. after a reload changed
+// the jobs and events appear or disappear without a transition
+func (l *lifecycle) reset() {
+	l.prev = nil
+}
+
+func (l *lifecycle) observe(snap *snapshot) {
+	cur := map[string]*health.Event{}
+	for _, e := range snap.events {
+		cur[aws.StringValue(e.Arn)] = e
+	}
+
+	prev := l.prev
+	l.prev = cur
+	if prev == nil {
+		return
+	}
+
+	for _, e := range snap.events {
+		switch diff(prev[aws.StringValue(e.Arn)], e) {
+		case transitionNew:
+			lifecycleCounter(eventsOpened, e).Inc()
+		case transitionUpdated:
+			lifecycleCounter(eventUpdates, e).Inc()
+		case transitionClosed:
+			lifecycleCounter(eventsClosed, e).Inc()
+			observeResolution(e)
+		}
+	}
+
+	// in aggregate mode closed events are not listed, they just disappear.
+	// Otherwise they disappear because they were excluded or filtered.
+	if !l.aggregate {
+		return
+	}
+	for arn, e := range prev {
+		if _, ok := cur[arn]; !ok && isActive(e) {
+			closed := disappeared(e, snap.timestamp)
+			lifecycleCounter(eventsClosed, closed).Inc()
+			observeResolution(closed)
+			l.addDisappeared(closed)
+		}
+	}
+}
+
+func (l *lifecycle) addDisappeared(e *health.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.disappeared = append(l.disappeared, e)
+	if n := len(l.disappeared); n > maxDisappeared {
+		l.disappeared = l.disappeared[n-maxDisappeared:]
+	}
+}
+
+// closedEvents returns the events that were closed by disappearing
+func (l *lifecycle) closedEvents() []*health.Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.disappeared
+}
+
+func lifecycleCounter(cv *prometheus.CounterVec, e *health.Event) prometheus.Counter {
+	return cv.WithLabelValues(aws.StringValue(e.EventTypeCategory), aws.StringValue(e.Region), aws.StringValue(e.Service))
+}
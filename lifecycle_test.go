@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+func TestLifecycleObserve(t *testing.T) {
+	updated := time.Unix(1700000000, 0)
+	event := func(arn, status string, lastUpdated time.Time) *health.Event {
+		return &health.Event{
+			Arn:               aws.String(arn),
+			EventTypeCategory: aws.String("issue"),
+			Region:            aws.String("eu-west-1"),
+			Service:           aws.String("LIFECYCLE"),
+			StatusCode:        aws.String(status),
+			LastUpdatedTime:   aws.Time(lastUpdated),
+		}
+	}
+
+	l := &lifecycle{aggregate: true}
+	l.observe(&snapshot{events: []*health.Event{event("arn:event/1", "open", updated)}})
+	l.observe(&snapshot{events: []*health.Event{
+		event("arn:event/1", "open", updated.Add(time.Minute)),
+		event("arn:event/2", "open", updated),
+		event("arn:event/3", "open", updated),
+	}})
+	l.observe(&snapshot{events: []*health.Event{
+		event("arn:event/1", "closed", updated.Add(2*time.Minute)),
+		event("arn:event/2", "open", updated),
+	}})
+
+	for _, test := range []struct {
+		name     string
+		value    float64
+		expected float64
+	}{
+		{"opened", metricValue(eventsOpened.WithLabelValues("issue", "eu-west-1", "LIFECYCLE")), 2},
+		{"updates", metricValue(eventUpdates.WithLabelValues("issue", "eu-west-1", "LIFECYCLE")), 1},
+		// arn:event/3 disappeared like closed events in aggregate mode
+		{"closed", metricValue(eventsClosed.WithLabelValues("issue", "eu-west-1", "LIFECYCLE")), 2},
+	} {
+		if test.value != test.expected {
+			t.Errorf("Invalid %s - Expected: %v Got: %v", test.name, test.expected, test.value)
+		}
+	}
+}
+
+func TestLifecycleDisappeared(t *testing.T) {
+	event := &health.Event{
+		Arn:               aws.String("arn:event/excluded"),
+		EventTypeCategory: aws.String("issue"),
+		Region:            aws.String("eu-west-1"),
+		Service:           aws.String("DISAPPEARED"),
+		StatusCode:        aws.String("open"),
+	}
+	closed := eventsClosed.WithLabelValues("issue", "eu-west-1", "DISAPPEARED")
+
+	// without aggregate mode the event was e.g. excluded, not closed
+	l := &lifecycle{}
+	l.observe(&snapshot{events: []*health.Event{event}})
+	l.observe(&snapshot{})
+	if v := metricValue(closed); v != 0 {
+		t.Errorf("Expected no closed event, got %v", v)
+	}
+
+	// the snapshot after a reset is the baseline
+	l = &lifecycle{aggregate: true}
+	l.observe(&snapshot{events: []*health.Event{event}})
+	l.reset()
+	l.observe(&snapshot{})
+	if v := metricValue(closed); v != 0 {
+		t.Errorf("Expected no closed event after reset, got %v", v)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// transitionNew is an event that was opened or announced
+	transitionNew = "new"
+	// transitionUpdated is an event with a new LastUpdatedTime
+	transitionUpdated = "updated"
+	// transitionClosed is an event that was closed
+	transitionClosed = "closed"
+
+	// LabelOutput defines the notification output, e.g. webhook
+	LabelOutput = "output"
+	// LabelResult defines the result of a notification, success or failure
+	LabelResult = "result"
+
+	// notifyQueueSize is the number of polls with transitions that can be
+	// queued while the outputs are busy
+	notifyQueueSize = 100
+)
+
+// notifications is the number of sent notifications per output and result
+var notifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name:      "notifications_total",
+	Namespace: Namespace,
+	Help:      "Total number of sent notifications by output and result",
+}, []string{LabelOutput, LabelResult})
+
+func init() {
+	prometheus.MustRegister(notifications)
+}
+
+// transition is a lifecycle change of an event between two polls
+type transition struct {
+	Type  string   `json:"type"`
+	Event apiEvent `json:"event"`
+}
+
+// output delivers transitions, e.g. to a webhook
+type output interface {
+	// name is used as output label of aws_health_notifications_total
+	name() string
+	// send delivers a single transition and retries on its own until ctx
+	// is done
+	send(ctx context.Context, t *transition) error
+}
+
+// syncer is an output that is additionally handed all open events after
+// every poll, e.g. to keep alerts firing
+type syncer interface {
+	output
+	sync(ctx context.Context, open []apiEvent) error
+}
+
+// inheritor is an output that takes over the state of the outputs it
+// replaces on reload, e.g. the threads of Slack messages
+type inheritor interface {
+	inherit(old output)
+}
+
+// notifier diffs consecutive snapshots by event ARN and hands the
+// transitions to the outputs. Outputs are called in the background so
+// slow receivers don't delay the polls.
+type notifier struct {
+	api *eventsAPI
+
+	// mu guards outputs and syncers, which are replaced on reload
+	mu      sync.Mutex
+	outputs []output
+	// syncers is true if any output implements syncer
+	syncers bool
+
+	// prev are the events of the previous snapshot by ARN, nil before the
+	// first snapshot
+	prev  map[string]*health.Event
+	queue chan *batch
+	// done is closed when run returned
+	done chan struct{}
+	// ctx of the outputs is canceled when drain gives up
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// batch are the transitions and open events of a single poll
+type batch struct {
+	transitions []*transition
+	open        []apiEvent
+}
+
+func newNotifier(api *eventsAPI, outputs []output) *notifier {
+	n := &notifier{
+		api:   api,
+		queue: make(chan *batch, notifyQueueSize),
+		done:  make(chan struct{}),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.setOutputs(outputs)
+	return n
+}
+
+// setOutputs replaces the outputs, e.g. on reload. Queued transitions are
+// sent to the new outputs.
+func (n *notifier) setOutputs(outputs []output) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.syncers = false
+	for _, o := range outputs {
+		if i, ok := o.(inheritor); ok {
+			for _, old := range n.outputs {
+				i.inherit(old)
+			}
+		}
+		if _, ok := o.(syncer); ok {
+			n.syncers = true
+		}
+	}
+	n.outputs = outputs
+}
+
+// observe queues the transitions between the previous snapshot and snap.
+// The first snapshot only sets the baseline, otherwise every known event
+// would be reported as new on startup. Open and upcoming events that are
+// no longer part of snap are reported as closed, e.g. closed events in
+// aggregate mode or events of an excluded account.
+func (n *notifier) observe(snap *snapshot) {
+	cur := map[string]*health.Event{}
+	for _, e := range snap.events {
+		cur[aws.StringValue(e.Arn)] = e
+	}
+
+	prev := n.prev
+	n.prev = cur
+
+	n.mu.Lock()
+	syncers := n.syncers
+	n.mu.Unlock()
+
+	b := &batch{}
+	for _, e := range snap.events {
+		if prev != nil {
+			if typ := diff(prev[aws.StringValue(e.Arn)], e); typ != "" {
+				b.transitions = append(b.transitions, &transition{Type: typ, Event: n.api.event(snap, e)})
+			}
+		}
+		if syncers && isActive(e) {
+			b.open = append(b.open, n.api.event(snap, e))
+		}
+	}
+	var gone []string
+	for arn, e := range prev {
+		if _, ok := cur[arn]; !ok && isActive(e) {
+			gone = append(gone, arn)
+		}
+	}
+	sort.Strings(gone)
+	for _, arn := range gone {
+		closed := disappeared(prev[arn], snap.timestamp)
+		b.transitions = append(b.transitions, &transition{Type: transitionClosed, Event: n.api.event(snap, closed)})
+	}
+	if len(b.transitions) == 0 && !syncers {
+		return
+	}
+
+	select {
+	case n.queue <- b:
+	default:
+		log.Printf("Notification queue is full, dropping %d transitions", len(b.transitions))
+	}
+}
+
+// run sends the queued transitions to all outputs, followed by the open
+// events to the syncers. It returns when the queue is closed by drain.
+func (n *notifier) run() {
+	defer close(n.done)
+	for b := range n.queue {
+		n.mu.Lock()
+		outputs := n.outputs
+		n.mu.Unlock()
+
+		for _, t := range b.transitions {
+			for _, o := range outputs {
+				n.result(o, o.send(n.ctx, t), t.Event.Arn)
+			}
+		}
+		for _, o := range outputs {
+			if s, ok := o.(syncer); ok {
+				n.result(o, s.sync(n.ctx, b.open), "open events")
+			}
+		}
+	}
+}
+
+// drain waits until the queued transitions are sent or ctx is done, e.g.
+// on shutdown, then retries in progress are canceled. observe must not be
+// called afterwards.
+func (n *notifier) drain(ctx context.Context) error {
+	close(n.queue)
+	defer n.cancel()
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("dropping %d queued notification batches: %v", len(n.queue), ctx.Err())
+	}
+}
+
+func (n *notifier) result(o output, err error, subject string) {
+	if err != nil {
+		log.Printf("Failed to send %s notification for %s: %v", o.name(), subject, err)
+		notifications.WithLabelValues(o.name(), "failure").Inc()
+		return
+	}
+	notifications.WithLabelValues(o.name(), "success").Inc()
+}
+
+// diff returns the transition from prev to cur or an empty string. prev is
+// nil for events that were not part of the previous snapshot.
+func diff(prev, cur *health.Event) string {
+	switch {
+	case prev == nil:
+		if isActive(cur) {
+			return transitionNew
+		}
+	case isActive(prev) && !isActive(cur):
+		return transitionClosed
+	case !aws.TimeValue(prev.LastUpdatedTime).Equal(aws.TimeValue(cur.LastUpdatedTime)):
+		return transitionUpdated
+	}
+	return ""
+}
+
+// disappeared returns a closed copy of the last seen version of e. Without
+// an end time the event ended when it disappeared at observed.
+func disappeared(e *health.Event, observed time.Time) *health.Event {
+	closed := *e
+	closed.StatusCode = aws.String(health.EventStatusCodeClosed)
+	if closed.EndTime == nil {
+		closed.EndTime = aws.Time(observed)
+	}
+	return &closed
+}
+
+// consoleURL links to the event in the AWS Health Dashboard
+func consoleURL(arn string) string {
+	return "https://health.aws.amazon.com/health/home#/account/event-log?eventID=" + url.QueryEscape(arn) + "&eventTab=details"
+}
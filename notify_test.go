@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+func TestDiff(t *testing.T) {
+	updated := time.Unix(1700000000, 0)
+	event := func(status string, lastUpdated time.Time) *health.Event {
+		return &health.Event{StatusCode: aws.String(status), LastUpdatedTime: aws.Time(lastUpdated)}
+	}
+
+	tests := []struct {
+		prev, cur *health.Event
+		expected  string
+	}{
+		{nil, event("open", updated), transitionNew},
+		{nil, event("upcoming", updated), transitionNew},
+		{nil, event("closed", updated), ""},
+		{event("open", updated), event("open", updated), ""},
+		{event("open", updated), event("open", updated.Add(time.Minute)), transitionUpdated},
+		{event("upcoming", updated), event("open", updated.Add(time.Minute)), transitionUpdated},
+		{event("open", updated), event("closed", updated.Add(time.Minute)), transitionClosed},
+		{event("closed", updated), event("closed", updated.Add(time.Minute)), transitionUpdated},
+	}
+	for i, test := range tests {
+		if got := diff(test.prev, test.cur); got != test.expected {
+			t.Errorf("%d: Expected %q, got %q", i, test.expected, got)
+		}
+	}
+}
+
+type mockOutput struct {
+	sent []*transition
+}
+
+func (o *mockOutput) name() string {
+	return "mock"
+}
+
+func (o *mockOutput) send(ctx context.Context, t *transition) error {
+	o.sent = append(o.sent, t)
+	return nil
+}
+
+func TestNotifierObserve(t *testing.T) {
+	open := &health.Event{Arn: aws.String("arn:event/open"), StatusCode: aws.String("open")}
+	o := &mockOutput{}
+	n := newNotifier(&eventsAPI{exporter: &exporter{}}, []output{o})
+
+	n.observe(&snapshot{events: []*health.Event{open}})
+	if len(n.queue) != 0 {
+		t.Fatalf("Expected no transitions for the first snapshot")
+	}
+
+	created := &health.Event{Arn: aws.String("arn:event/new"), StatusCode: aws.String("upcoming")}
+	n.observe(&snapshot{events: []*health.Event{open, created}})
+	n.observe(&snapshot{events: []*health.Event{open, created}})
+
+	// events that disappear are closed, e.g. in aggregate mode
+	n.observe(&snapshot{events: []*health.Event{created}})
+	close(n.queue)
+	n.run()
+
+	if len(o.sent) != 2 {
+		t.Fatalf("Invalid transitions: %v", o.sent)
+	}
+	if o.sent[0].Type != transitionNew || o.sent[0].Event.Arn != "arn:event/new" {
+		t.Errorf("Invalid transition: %v", o.sent[0])
+	}
+	if o.sent[1].Type != transitionClosed || o.sent[1].Event.Arn != "arn:event/open" || o.sent[1].Event.StatusCode != "closed" {
+		t.Errorf("Expected the disappeared event to be closed, got %v", o.sent[1])
+	}
+}
+
+type mockSyncer struct {
+	mockOutput
+	open [][]apiEvent
+}
+
+func (o *mockSyncer) sync(ctx context.Context, open []apiEvent) error {
+	o.open = append(o.open, open)
+	return nil
+}
+
+func TestNotifierSync(t *testing.T) {
+	open := &health.Event{Arn: aws.String("arn:event/open"), StatusCode: aws.String("open")}
+	closed := &health.Event{Arn: aws.String("arn:event/closed"), StatusCode: aws.String("closed")}
+	o := &mockSyncer{}
+	n := newNotifier(&eventsAPI{exporter: &exporter{}}, []output{o})
+
+	n.observe(&snapshot{events: []*health.Event{open, closed}})
+	n.observe(&snapshot{events: []*health.Event{closed}})
+	close(n.queue)
+	n.run()
+
+	if len(o.open) != 2 || len(o.open[0]) != 1 || o.open[0][0].Arn != "arn:event/open" || len(o.open[1]) != 0 {
+		t.Errorf("Invalid open events: %v", o.open)
+	}
+	if len(o.sent) != 1 || o.sent[0].Type != transitionClosed {
+		t.Errorf("Expected the disappeared event to be closed, got %v", o.sent)
+	}
+}
+
+// blockingOutput blocks every send until release is closed
+type blockingOutput struct {
+	release chan struct{}
+}
+
+func (o *blockingOutput) name() string {
+	return "blocking"
+}
+
+func (o *blockingOutput) send(ctx context.Context, t *transition) error {
+	<-o.release
+	return nil
+}
+
+func TestNotifierDrain(t *testing.T) {
+	open := &health.Event{Arn: aws.String("arn:event/open"), StatusCode: aws.String("open")}
+	created := &health.Event{Arn: aws.String("arn:event/new"), StatusCode: aws.String("upcoming")}
+
+	o := &mockOutput{}
+	n := newNotifier(&eventsAPI{exporter: &exporter{}}, []output{o})
+	go n.run()
+	n.observe(&snapshot{events: []*health.Event{open}})
+	n.observe(&snapshot{events: []*health.Event{open, created}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := n.drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.sent) != 1 {
+		t.Errorf("Expected the queued transition to be sent, got %v", o.sent)
+	}
+
+	blocking := &blockingOutput{release: make(chan struct{})}
+	defer close(blocking.release)
+	n = newNotifier(&eventsAPI{exporter: &exporter{}}, []output{blocking})
+	go n.run()
+	n.observe(&snapshot{events: []*health.Event{open}})
+	n.observe(&snapshot{events: []*health.Event{open, created}})
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := n.drain(ctx); err == nil {
+		t.Errorf("Expected the drain to time out")
+	}
+}
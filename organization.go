@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/aws/aws-sdk-go/service/health/healthiface"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// LabelAccountID defines the AWS account affected by the event, only set in organization mode and
+	// for the accounts of --config.file
+	LabelAccountID = "account_id"
+	// LabelStatus defines the status of the organizational view, e.g. ENABLED, DISABLED, PENDING
+	LabelStatus = "status"
+
+	// orgViewEnabled is the status of an enabled organizational view
+	orgViewEnabled = "ENABLED"
+
+	// accountsRetention is how long the affected accounts of an event are
+	// cached after no job returned the event anymore
+	accountsRetention = 24 * time.Hour
+)
+
+var (
+	// orgViewStatuses are the possible statuses of the organizational view
+	orgViewStatuses = []string{orgViewEnabled, "DISABLED", "PENDING"}
+
+	// eventAccountInfoDesc is an info metric with one series per event and affected account
+	eventAccountInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "", "event_account_info"),
+		"Info metric with one series per aws health event and affected account",
+		[]string{LabelARN, LabelAccountID},
+		nil,
+	)
+
+	// orgViewStatus is 1 for the current status of the organizational view
+	orgViewStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "organization_view_status",
+		Namespace: Namespace,
+		Help:      "Status of the organizational view of AWS Health, 1 for the current status",
+	}, []string{LabelStatus})
+)
+
+func init() {
+	prometheus.MustRegister(orgViewStatus)
+}
+
+// organization fetches the events of all accounts of an AWS organization,
+// see --aws.organization. The exporter has to run in the management or
+// delegated administrator account.
+type organization struct {
+	api healthiface.HealthAPI
+
+	// include limits the events to these accounts, all accounts if empty
+	include  []string
+	included map[string]bool
+	// exclude drops these accounts from the affected accounts
+	exclude map[string]bool
+
+	mu sync.Mutex
+	// accounts caches the affected accounts by event ARN
+	accounts map[string]*accountsEntry
+}
+
+type accountsEntry struct {
+	lastUpdated time.Time
+	accounts    []string
+	// seen is when a job last returned the event
+	seen time.Time
+}
+
+func newOrganization(api healthiface.HealthAPI, include, exclude []string) *organization {
+	o := &organization{
+		api:      api,
+		include:  include,
+		included: map[string]bool{},
+		exclude:  map[string]bool{},
+		accounts: map[string]*accountsEntry{},
+	}
+	for _, id := range include {
+		o.included[id] = true
+	}
+	for _, id := range exclude {
+		o.exclude[id] = true
+	}
+	return o
+}
+
+// withAPI returns an organization with the same include and exclude lists
+// that uses api, e.g. for a /probe target
+func (o *organization) withAPI(api healthiface.HealthAPI) *organization {
+	var exclude []string
+	for id := range o.exclude {
+		exclude = append(exclude, id)
+	}
+	return newOrganization(api, o.include, exclude)
+}
+
+// scrape returns the events of the organization together with the affected
+// accounts of account specific events. Account specific events without any
+// remaining account after applying the include and exclude lists are dropped.
+func (o *organization) scrape(ctx context.Context, filter *health.EventFilter) (*snapshot, error) {
+	if err := o.checkStatus(ctx); err != nil {
+		return nil, err
+	}
+
+	var events []*health.Event
+
+	err := o.api.DescribeEventsForOrganizationPagesWithContext(ctx, &health.DescribeEventsForOrganizationInput{
+		Filter: o.filter(filter),
+	}, func(out *health.DescribeEventsForOrganizationOutput, lastPage bool) bool {
+		scrapePages.Inc()
+		for _, e := range out.Events {
+			events = append(events, fromOrganizationEvent(e))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &snapshot{accounts: map[string][]string{}}
+	for _, e := range events {
+		arn := aws.StringValue(e.Arn)
+		if aws.StringValue(e.EventScopeCode) != health.EventScopeCodeAccountSpecific {
+			snap.events = append(snap.events, e)
+			continue
+		}
+
+		accounts, err := o.affectedAccounts(ctx, e)
+		if err != nil {
+			return nil, err
+		}
+		accounts = o.allowed(accounts)
+		if len(accounts) == 0 {
+			continue
+		}
+		snap.events = append(snap.events, e)
+		snap.accounts[arn] = accounts
+	}
+
+	// the jobs return different events, an entry is only dropped once no
+	// job returned its event for a while
+	o.mu.Lock()
+	for arn, entry := range o.accounts {
+		if time.Since(entry.seen) > accountsRetention {
+			delete(o.accounts, arn)
+		}
+	}
+	o.mu.Unlock()
+
+	return snap, nil
+}
+
+// checkStatus returns an error unless the organizational view is enabled.
+// Without it the organization API calls fail with opaque errors.
+func (o *organization) checkStatus(ctx context.Context) error {
+	status, err := orgStatus(ctx, o.api)
+	if err != nil {
+		return fmt.Errorf("failed to get the status of the organizational view: %v", err)
+	}
+	if status != orgViewEnabled {
+		return fmt.Errorf("the organizational view of AWS Health is %s, enable it with `aws-health-exporter org enable`", status)
+	}
+	return nil
+}
+
+// affectedAccounts returns the accounts affected by e, they are only fetched
+// again when the event was updated.
+func (o *organization) affectedAccounts(ctx context.Context, e *health.Event) ([]string, error) {
+	arn := aws.StringValue(e.Arn)
+	lastUpdated := aws.TimeValue(e.LastUpdatedTime)
+
+	o.mu.Lock()
+	entry, ok := o.accounts[arn]
+	if ok {
+		entry.seen = time.Now()
+	}
+	o.mu.Unlock()
+	if ok && entry.lastUpdated.Equal(lastUpdated) {
+		return entry.accounts, nil
+	}
+
+	var accounts []string
+	err := o.api.DescribeAffectedAccountsForOrganizationPagesWithContext(ctx, &health.DescribeAffectedAccountsForOrganizationInput{
+		EventArn: e.Arn,
+	}, func(out *health.DescribeAffectedAccountsForOrganizationOutput, lastPage bool) bool {
+		accounts = append(accounts, aws.StringValueSlice(out.AffectedAccounts)...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	o.accounts[arn] = &accountsEntry{lastUpdated: lastUpdated, accounts: accounts, seen: time.Now()}
+	o.mu.Unlock()
+
+	return accounts, nil
+}
+
+// allowed applies the include and exclude lists to accounts
+func (o *organization) allowed(accounts []string) []string {
+	var allowed []string
+	for _, id := range accounts {
+		if o.exclude[id] || (len(o.included) > 0 && !o.included[id]) {
+			continue
+		}
+		allowed = append(allowed, id)
+	}
+	return allowed
+}
+
+// filter converts the event filter of the exporter to an organization
+// filter, see checkOrgFilter for the fields that can't be converted
+func (o *organization) filter(f *health.EventFilter) *health.OrganizationEventFilter {
+	of := &health.OrganizationEventFilter{
+		EntityArns:          f.EntityArns,
+		EntityValues:        f.EntityValues,
+		EventStatusCodes:    f.EventStatusCodes,
+		EventTypeCategories: f.EventTypeCategories,
+		EventTypeCodes:      f.EventTypeCodes,
+		Regions:             f.Regions,
+		Services:            f.Services,
+	}
+	if len(f.StartTimes) > 0 {
+		of.StartTime = f.StartTimes[0]
+	}
+	if len(f.EndTimes) > 0 {
+		of.EndTime = f.EndTimes[0]
+	}
+	if len(f.LastUpdatedTimes) > 0 {
+		of.LastUpdatedTime = f.LastUpdatedTimes[0]
+	}
+	if len(o.include) > 0 {
+		of.AwsAccountIds = aws.StringSlice(o.include)
+	}
+	return of
+}
+
+// checkOrgFilter returns an error for the fields of f the organization
+// filter can't express, they would be silently dropped
+func checkOrgFilter(f *health.EventFilter) error {
+	unsupported := []struct {
+		field string
+		set   bool
+	}{
+		{"availability_zones", len(f.AvailabilityZones) > 0},
+		{"event_arns", len(f.EventArns) > 0},
+		{"tags", len(f.Tags) > 0},
+		{"more than one of start_times", len(f.StartTimes) > 1},
+		{"more than one of end_times", len(f.EndTimes) > 1},
+		{"more than one of last_updated_times", len(f.LastUpdatedTimes) > 1},
+	}
+	for _, u := range unsupported {
+		if u.set {
+			return fmt.Errorf("%s is not supported with --aws.organization", u.field)
+		}
+	}
+	return nil
+}
+
+func fromOrganizationEvent(e *health.OrganizationEvent) *health.Event {
+	return &health.Event{
+		Arn:               e.Arn,
+		EndTime:           e.EndTime,
+		EventScopeCode:    e.EventScopeCode,
+		EventTypeCategory: e.EventTypeCategory,
+		EventTypeCode:     e.EventTypeCode,
+		LastUpdatedTime:   e.LastUpdatedTime,
+		Region:            e.Region,
+		Service:           e.Service,
+		StartTime:         e.StartTime,
+		StatusCode:        e.StatusCode,
+	}
+}
+
+// orgStatus returns the status of the organizational view and updates the
+// organization_view_status metric
+func orgStatus(ctx context.Context, api healthiface.HealthAPI) (string, error) {
+	out, err := api.DescribeHealthServiceStatusForOrganizationWithContext(ctx, &health.DescribeHealthServiceStatusForOrganizationInput{})
+	if err != nil {
+		return "", err
+	}
+
+	status := aws.StringValue(out.HealthServiceAccessStatusForOrganization)
+	for _, s := range orgViewStatuses {
+		v := 0.
+		if s == status {
+			v = 1
+		}
+		orgViewStatus.WithLabelValues(s).Set(v)
+	}
+	return status, nil
+}
+
+// runOrgCommand runs the `org status`, `org enable` and `org disable`
+// subcommands and returns the resulting status of the organizational view
+func runOrgCommand(ctx context.Context, api healthiface.HealthAPI, action string) (string, error) {
+	switch action {
+	case "enable":
+		if _, err := api.EnableHealthServiceAccessForOrganizationWithContext(ctx, &health.EnableHealthServiceAccessForOrganizationInput{}); err != nil {
+			return "", err
+		}
+	case "disable":
+		if _, err := api.DisableHealthServiceAccessForOrganizationWithContext(ctx, &health.DisableHealthServiceAccessForOrganizationInput{}); err != nil {
+			return "", err
+		}
+	case "status":
+	default:
+		return "", fmt.Errorf("unknown org command %q", action)
+	}
+	return orgStatus(ctx, api)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOrganizationScrape(t *testing.T) {
+	api := &mockHealthAPI{
+		events: []*health.Event{
+			&health.Event{
+				Arn:               aws.String("arn:event/public"),
+				EventScopeCode:    aws.String("PUBLIC"),
+				EventTypeCategory: aws.String("issue"),
+				Region:            aws.String("eu-west-1"),
+				Service:           aws.String("EC2"),
+				StatusCode:        aws.String("open"),
+			},
+			&health.Event{
+				Arn:               aws.String("arn:event/retirement"),
+				EventScopeCode:    aws.String("ACCOUNT_SPECIFIC"),
+				EventTypeCategory: aws.String("scheduledChange"),
+				Region:            aws.String("eu-west-1"),
+				Service:           aws.String("EC2"),
+				StatusCode:        aws.String("upcoming"),
+			},
+			&health.Event{
+				Arn:               aws.String("arn:event/excluded"),
+				EventScopeCode:    aws.String("ACCOUNT_SPECIFIC"),
+				EventTypeCategory: aws.String("scheduledChange"),
+				Region:            aws.String("eu-west-1"),
+				Service:           aws.String("RDS"),
+				StatusCode:        aws.String("upcoming"),
+			},
+		},
+		accounts: map[string][]string{
+			"arn:event/retirement": {"111111111111", "222222222222", "333333333333"},
+			"arn:event/excluded":   {"333333333333"},
+		},
+	}
+	e := &exporter{
+		api:  api,
+		jobs: []*job{{filter: &health.EventFilter{}}},
+		org:  newOrganization(api, nil, []string{"333333333333"}),
+	}
+
+	snap, err := e.scrape(context.Background(), e.jobs[0].filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.events) != 2 {
+		t.Fatalf("Expected the event of the excluded account to be dropped, got %d events", len(snap.events))
+	}
+	if n := api.calls["DescribeAffectedAccountsForOrganization"]; n != 2 {
+		t.Errorf("Expected affected accounts only for account specific events, got %d calls", n)
+	}
+
+	gv := prometheus.NewGaugeVec(eventOpts, e.labels())
+	countEvents(gv, snap)
+	if v := metricValue(gv.WithLabelValues("scheduledChange", "eu-west-1", "EC2", "upcoming", "111111111111")); v != 1 {
+		t.Errorf("Invalid value - Expected: 1 Got: %v", v)
+	}
+	if v := metricValue(gv.WithLabelValues("issue", "eu-west-1", "EC2", "open", "")); v != 1 {
+		t.Errorf("Invalid value - Expected: 1 Got: %v", v)
+	}
+
+	// affected accounts are cached until the event is updated
+	if _, err := e.scrape(context.Background(), e.jobs[0].filter); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.calls["DescribeAffectedAccountsForOrganization"]; n != 2 {
+		t.Errorf("Expected cached affected accounts, got %d calls", n)
+	}
+
+	// a job that doesn't return the events keeps the cache of the others
+	all := api.events
+	api.events = all[:1]
+	if _, err := e.scrape(context.Background(), e.jobs[0].filter); err != nil {
+		t.Fatal(err)
+	}
+	api.events = all
+	if _, err := e.scrape(context.Background(), e.jobs[0].filter); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.calls["DescribeAffectedAccountsForOrganization"]; n != 2 {
+		t.Errorf("Expected the cache to be shared by the jobs, got %d calls", n)
+	}
+}
+
+func TestOrganizationFilter(t *testing.T) {
+	o := newOrganization(nil, []string{"111111111111"}, nil)
+	f := o.filter(&health.EventFilter{Services: aws.StringSlice([]string{"EC2"})})
+
+	if len(f.Services) != 1 || aws.StringValue(f.AwsAccountIds[0]) != "111111111111" {
+		t.Errorf("Invalid organization filter: %v", f)
+	}
+	if got := o.allowed([]string{"111111111111", "222222222222"}); len(got) != 1 {
+		t.Errorf("Expected only included accounts, got %v", got)
+	}
+	from := time.Unix(1700000000, 0)
+	f = o.filter(&health.EventFilter{
+		EntityArns:       aws.StringSlice([]string{"arn:aws:ec2:eu-west-1:111111111111:instance/i-1"}),
+		EntityValues:     aws.StringSlice([]string{"i-1"}),
+		LastUpdatedTimes: []*health.DateTimeRange{{From: aws.Time(from)}},
+	})
+	if len(f.EntityArns) != 1 || len(f.EntityValues) != 1 || !aws.TimeValue(f.LastUpdatedTime.From).Equal(from) {
+		t.Errorf("Expected the entities and the time range to be passed through, got %v", f)
+	}
+
+	for _, filter := range []*health.EventFilter{
+		{EventArns: aws.StringSlice([]string{"arn:event/1"})},
+		{AvailabilityZones: aws.StringSlice([]string{"eu-west-1a"})},
+		{Tags: []map[string]*string{{"team": aws.String("web")}}},
+		{StartTimes: []*health.DateTimeRange{{From: aws.Time(from)}, {To: aws.Time(from)}}},
+	} {
+		if err := checkOrgFilter(filter); err == nil {
+			t.Errorf("Expected an error for %v", filter)
+		}
+	}
+	if err := checkOrgFilter(&health.EventFilter{StartTimes: []*health.DateTimeRange{{From: aws.Time(from)}}}); err != nil {
+		t.Errorf("Expected a single time range to be supported, got %v", err)
+	}
+}
+
+func TestOrganizationStatus(t *testing.T) {
+	api := &mockHealthAPI{orgStatus: "DISABLED"}
+	o := newOrganization(api, nil, nil)
+
+	if _, err := o.scrape(context.Background(), &health.EventFilter{}); err == nil {
+		t.Errorf("Expected an error with a disabled organizational view")
+	}
+	if n := api.calls["DescribeEventsForOrganization"]; n != 0 {
+		t.Errorf("Expected no events to be fetched, got %d calls", n)
+	}
+	if v := metricValue(orgViewStatus.WithLabelValues("DISABLED")); v != 1 {
+		t.Errorf("Invalid organization view status - Expected: 1 Got: %v", v)
+	}
+
+	status, err := runOrgCommand(context.Background(), api, "enable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "ENABLED" || o.checkStatus(context.Background()) != nil {
+		t.Errorf("Expected the organizational view to be enabled, got %s", status)
+	}
+
+	if _, err := runOrgCommand(context.Background(), api, "unknown"); err == nil {
+		t.Errorf("Expected an error for unknown org commands")
+	}
+}
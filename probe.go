@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/aws/aws-sdk-go/service/health/healthiface"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// prober serves /probe?target=&module= in the style of the
+// blackbox_exporter. The target is an account of --config.file by ID or
+// name, a profile of the shared AWS config or empty for the credentials of
+// the exporter. The module is the name of a job, empty for the filter flags.
+// The targets are polled like the exporter, e.g. in organization mode.
+type prober struct {
+	api      healthiface.HealthAPI
+	perEvent bool
+	// org and aggregate are the modes of the exporter, org is nil unless
+	// running in organization mode
+	org       *organization
+	aggregate bool
+	// ttl is how long the snapshot of a target and module is cached
+	ttl time.Duration
+	// newAPI returns the API for a profile of the shared AWS config
+	newAPI func(profile string) (healthiface.HealthAPI, error)
+
+	// mu guards the accounts and modules, which are replaced on reload,
+	// and the caches
+	mu sync.Mutex
+	// accounts maps account IDs and names to the accounts
+	accounts map[string]*account
+	modules  map[string]*job
+	profiles map[string]healthiface.HealthAPI
+	cache    map[[2]string]*probeEntry
+}
+
+type probeEntry struct {
+	snap    *snapshot
+	expires time.Time
+}
+
+func newProber(e *exporter, acc *accounts, jobs []*job, ttl time.Duration) *prober {
+	p := &prober{
+		api:       e.api,
+		perEvent:  e.perEvent,
+		org:       e.org,
+		aggregate: e.aggregate,
+		ttl:       ttl,
+		newAPI:    profileAPI,
+		profiles:  map[string]healthiface.HealthAPI{},
+		cache:     map[[2]string]*probeEntry{},
+	}
+	p.update(acc, jobs)
+	return p
+}
+
+// update replaces the accounts and modules, e.g. on reload. The cached
+// snapshots of modules whose filter changed are dropped.
+func (p *prober) update(acc *accounts, jobs []*job) {
+	accounts := map[string]*account{}
+	if acc != nil {
+		for _, a := range acc.accounts {
+			accounts[a.id] = a
+			accounts[a.name] = a
+		}
+	}
+	modules := map[string]*job{}
+	for _, j := range jobs {
+		modules[j.name] = j
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.cache {
+		prev, cur := p.modules[key[1]], modules[key[1]]
+		if cur == nil || !reflect.DeepEqual(prev.filter, cur.filter) {
+			delete(p.cache, key)
+		}
+	}
+	p.accounts, p.modules = accounts, modules
+}
+
+// profileAPI returns the API with the credentials of a shared config
+// profile. The SDK falls back to the default credentials for unknown
+// profiles, so they are rejected upfront.
+func profileAPI(profile string) (healthiface.HealthAPI, error) {
+	if !sharedProfiles()[profile] {
+		return nil, fmt.Errorf("no profile %s in the shared AWS config", profile)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{Region: aws.String(APIRegion)},
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	api := health.New(sess)
+	instrumentHandlers(&api.Handlers)
+	return api, nil
+}
+
+// sharedProfiles returns the names of the profiles of the shared AWS config
+// and credentials files
+func sharedProfiles() map[string]bool {
+	files := []string{os.Getenv("AWS_CONFIG_FILE"), os.Getenv("AWS_SHARED_CREDENTIALS_FILE")}
+	if files[0] == "" {
+		files[0] = defaults.SharedConfigFilename()
+	}
+	if files[1] == "" {
+		files[1] = defaults.SharedCredentialsFilename()
+	}
+
+	profiles := map[string]bool{}
+	for _, path := range files {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+				continue
+			}
+			// [profile name] in the config file, [name] in the credentials file
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			profiles[strings.TrimSpace(strings.TrimPrefix(name, "profile "))] = true
+		}
+	}
+	return profiles
+}
+
+func (p *prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, module := r.URL.Query().Get("target"), r.URL.Query().Get("module")
+	p.mu.Lock()
+	j, ok := p.modules[module]
+	p.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", module), http.StatusBadRequest)
+		return
+	}
+	api, key, err := p.target(target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	snap, cached, err := p.snapshot(r.Context(), api, key, j)
+	duration := time.Since(start).Seconds()
+	if cached {
+		duration = 0
+	}
+
+	registry := prometheus.NewRegistry()
+	success := 0.
+	if err != nil {
+		log.Printf("Probe of target %q with module %q failed: %v", target, module, err)
+	} else {
+		success = 1
+		// a single unnamed job, Prometheus adds the target labels. In
+		// organization mode the events are split by account.
+		registry.MustRegister(&exporter{jobs: []*job{{filter: j.filter, last: snap}}, perEvent: p.perEvent, org: p.org, last: snap})
+	}
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Whether the probe of the AWS Health API was successful",
+	}, func() float64 { return success }))
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "Duration of the probe of the AWS Health API, 0 if served from the cache",
+	}, func() float64 { return duration }))
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// target returns the API of target and the key it is cached by, accounts
+// are cached by ID. Only profiles of the shared AWS config are kept, so the
+// cache is bounded by the config.
+func (p *prober) target(target string) (healthiface.HealthAPI, string, error) {
+	if target == "" {
+		return p.api, "", nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if a, ok := p.accounts[target]; ok {
+		return a.api, a.id, nil
+	}
+	if api, ok := p.profiles[target]; ok {
+		return api, target, nil
+	}
+	api, err := p.newAPI(target)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown target %q: %v", target, err)
+	}
+	p.profiles[target] = api
+	return api, target, nil
+}
+
+// snapshot returns the cached snapshot of the target with the given key and
+// the module or polls the events. Failed polls are not cached.
+func (p *prober) snapshot(ctx context.Context, api healthiface.HealthAPI, target string, j *job) (*snapshot, bool, error) {
+	key := [2]string{target, j.name}
+	now := time.Now()
+
+	p.mu.Lock()
+	entry, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.snap, true, nil
+	}
+
+	e := &exporter{api: api, aggregate: p.aggregate}
+	if p.org != nil {
+		// the organization caches the affected accounts of its own polls
+		e.org = p.org.withAPI(api)
+	}
+	snap, err := e.scrape(ctx, j.filter)
+	if err != nil {
+		return nil, false, err
+	}
+	snap.timestamp = now
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, entry := range p.cache {
+		if now.After(entry.expires) {
+			delete(p.cache, k)
+		}
+	}
+	p.cache[key] = &probeEntry{snap: snap, expires: now.Add(p.ttl)}
+	return snap, false, nil
+}
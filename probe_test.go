@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/aws/aws-sdk-go/service/health/healthiface"
+)
+
+func TestProbe(t *testing.T) {
+	ec2 := &health.Event{Arn: aws.String("arn:event/ec2"), EventTypeCategory: aws.String("issue"), Region: aws.String("eu-west-1"), Service: aws.String("EC2"), StatusCode: aws.String("open")}
+	rds := &health.Event{Arn: aws.String("arn:event/rds"), EventTypeCategory: aws.String("issue"), Region: aws.String("us-east-1"), Service: aws.String("RDS"), StatusCode: aws.String("open")}
+	own := &mockHealthAPI{}
+	production := &mockHealthAPI{events: []*health.Event{ec2, rds}}
+
+	acc := &accounts{accounts: []*account{{id: "123456789012", name: "production", api: production}}}
+	jobs := []*job{{name: "europe", filter: &health.EventFilter{Regions: aws.StringSlice([]string{"eu-west-1"})}}}
+	p := newProber(&exporter{api: own}, acc, jobs, time.Minute)
+	p.newAPI = func(profile string) (healthiface.HealthAPI, error) {
+		return nil, errors.New("profile not found")
+	}
+
+	probe := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?"+query, nil))
+		return rec
+	}
+
+	rec := probe("target=production&module=europe")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	for _, expected := range []string{
+		`aws_health_events{category="issue",region="eu-west-1",service="EC2",status_code="open"} 1`,
+		"probe_success 1",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in %s", expected, body)
+		}
+	}
+	if strings.Contains(body, "RDS") {
+		t.Errorf("Expected the events to be filtered by the module, got %s", body)
+	}
+
+	// the second probe is served from the cache
+	probe("target=123456789012&module=europe")
+	if n := production.calls["DescribeEvents"]; n != 1 {
+		t.Errorf("Expected 1 DescribeEvents call, got %d", n)
+	}
+	if rec := probe("target=123456789012&module=europe"); !strings.Contains(rec.Body.String(), "probe_duration_seconds 0") {
+		t.Errorf("Expected cached probe, got %s", rec.Body)
+	}
+
+	if rec := probe("target=unknown&module=europe"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown target, got %d", rec.Code)
+	}
+	if rec := probe("module=unknown"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown module, got %d", rec.Code)
+	}
+
+	own.err = errors.New("AccessDenied")
+	if rec := probe("module=europe"); !strings.Contains(rec.Body.String(), "probe_success 0") {
+		t.Errorf("Expected failed probe, got %s", rec.Body)
+	}
+}
+
+func TestProbeModes(t *testing.T) {
+	closed := &health.Event{Arn: aws.String("arn:event/closed"), EventTypeCategory: aws.String("issue"), Region: aws.String("eu-west-1"), Service: aws.String("EC2"), StatusCode: aws.String("closed")}
+	jobs := []*job{{name: "ec2", filter: &health.EventFilter{Regions: aws.StringSlice([]string{"eu-west-1"}), Services: aws.StringSlice([]string{"EC2"})}}}
+
+	api := &mockHealthAPI{events: []*health.Event{closed}}
+	p := newProber(&exporter{api: api, aggregate: true}, nil, jobs, time.Minute)
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/probe?module=ec2", nil))
+	if n := api.calls["DescribeEventAggregates"]; n != 1 {
+		t.Errorf("Expected the closed events to be aggregated like /metrics, got %d calls", n)
+	}
+
+	api = &mockHealthAPI{events: []*health.Event{closed}}
+	p = newProber(&exporter{api: api, org: newOrganization(api, nil, nil)}, nil, jobs, time.Minute)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?module=ec2", nil))
+	if n := api.calls["DescribeEventsForOrganization"]; n != 1 {
+		t.Errorf("Expected the events of the organization like /metrics, got %d calls", n)
+	}
+	if !strings.Contains(rec.Body.String(), `account_id=""`) {
+		t.Errorf("Expected the events by account, got %s", rec.Body)
+	}
+}
+
+func TestSharedProfiles(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	credentials := filepath.Join(dir, "credentials")
+	ioutil.WriteFile(config, []byte("[default]\nregion = eu-west-1\n\n[profile staging]\nrole_arn = arn:aws:iam::123456789012:role/health\nsource_profile = default\n"), 0600)
+	ioutil.WriteFile(credentials, []byte("[ production ]\naws_access_key_id = AKIA\n"), 0600)
+	t.Setenv("AWS_CONFIG_FILE", config)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentials)
+
+	profiles := sharedProfiles()
+	for _, name := range []string{"default", "staging", "production"} {
+		if !profiles[name] {
+			t.Errorf("Expected profile %s in %v", name, profiles)
+		}
+	}
+
+	// unknown profiles would silently use the default credentials
+	if _, err := profileAPI("typo"); err == nil {
+		t.Errorf("Expected an error for an unknown profile")
+	}
+}
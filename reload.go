@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// reloadSuccess is whether the last reload of the configuration was successful
+	reloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "config_last_reload_successful",
+		Namespace: Namespace,
+		Help:      "Whether the last configuration reload attempt was successful",
+	})
+	// reloadTimestamp is the time of the last successful reload
+	reloadTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Namespace: Namespace,
+		Help:      "Unix time of the last successful configuration reload",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reloadSuccess)
+	prometheus.MustRegister(reloadTimestamp)
+}
+
+// settings is the part of the configuration that can be reloaded: the jobs
+// and accounts of --config.file and the notification outputs with their
+// secret and token files
+type settings struct {
+	jobs     []*job
+	accounts *accounts
+	outputs  []output
+}
+
+// reloader re-reads the configuration on SIGHUP and POST /-/reload. A valid
+// configuration replaces the current one, the snapshots, caches and the
+// state of the outputs are kept. An invalid one is logged and ignored.
+type reloader struct {
+	// ctx ends the polls of the applied jobs
+	ctx context.Context
+	// load reads and validates the configuration
+	load     func() (*settings, error)
+	exporter *exporter
+	prober   *prober
+	jitter   time.Duration
+
+	// mu serializes the reloads
+	mu sync.Mutex
+}
+
+func newReloader(ctx context.Context, load func() (*settings, error), e *exporter, p *prober, jitter time.Duration) *reloader {
+	return &reloader{ctx: ctx, load: load, exporter: e, prober: p, jitter: jitter}
+}
+
+// reload loads the configuration and applies it if it is valid
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.load()
+	if err != nil {
+		reloadSuccess.Set(0)
+		return err
+	}
+	r.apply(s)
+	return nil
+}
+
+// apply swaps in s, it is also used for the configuration at startup
+func (r *reloader) apply(s *settings) {
+	r.exporter.apply(r.ctx, s.jobs, s.accounts, r.jitter)
+	r.prober.update(s.accounts, s.jobs)
+	if r.exporter.notifier != nil {
+		r.exporter.notifier.setOutputs(s.outputs)
+	}
+
+	reloadSuccess.Set(1)
+	reloadTimestamp.SetToCurrentTime()
+}
+
+// watch reloads the configuration on every signal of c until ctx is done
+func (r *reloader) watch(c <-chan os.Signal) {
+	for {
+		select {
+		case <-c:
+		case <-r.ctx.Done():
+			return
+		}
+		log.Println("Received SIGHUP, reloading the configuration")
+		if err := r.reload(); err != nil {
+			log.Printf("Failed to reload the configuration: %v", err)
+		}
+	}
+}
+
+// ServeHTTP reloads the configuration on POST /-/reload
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		log.Printf("Failed to reload the configuration: %v", err)
+		http.Error(w, "Failed to reload the configuration: "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/aws/aws-sdk-go/service/health/healthiface"
+)
+
+// unavailableAPI fails every poll, so the polls started by a reload don't
+// replace the snapshots under test
+type unavailableAPI struct {
+	healthiface.HealthAPI
+}
+
+func (api *unavailableAPI) DescribeEventsPagesWithContext(ctx aws.Context, in *health.DescribeEventsInput, fn func(*health.DescribeEventsOutput, bool) bool, opts ...request.Option) error {
+	return errors.New("unavailable")
+}
+
+func TestReload(t *testing.T) {
+	europe := &health.EventFilter{Regions: aws.StringSlice([]string{"eu-west-1"})}
+	snap := &snapshot{events: []*health.Event{{Arn: aws.String("arn:event/ec2")}}, timestamp: time.Now()}
+
+	e := &exporter{api: &unavailableAPI{}, last: snap, complete: true}
+	e.apply(context.Background(), []*job{{name: "europe", filter: europe, interval: time.Hour, last: snap}}, nil, 0)
+	defer e.apply(context.Background(), nil, nil, 0)
+
+	var loadErr error
+	load := func() (*settings, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return &settings{jobs: []*job{
+			{name: "europe", filter: &health.EventFilter{Regions: aws.StringSlice([]string{"eu-west-1"})}, interval: time.Hour},
+			{name: "rds", filter: &health.EventFilter{Services: aws.StringSlice([]string{"RDS"})}, interval: time.Hour},
+		}}, nil
+	}
+	r := newReloader(context.Background(), load, e, newProber(e, nil, nil, time.Minute), 0)
+	e.mu.RLock()
+	europeJob := e.jobs[0]
+	e.mu.RUnlock()
+	e.lifecycle.prev = map[string]*health.Event{"arn:event/ec2": snap.events[0]}
+
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if v := metricValue(reloadSuccess); v != 1 {
+		t.Errorf("Expected successful reload, got %v", v)
+	}
+	e.mu.RLock()
+	if len(e.jobs) != 2 {
+		t.Errorf("Expected 2 jobs, got %d", len(e.jobs))
+	}
+	if e.jobs[0].last != snap {
+		t.Errorf("Expected the unchanged job to keep its snapshot")
+	}
+	if e.jobs[0] != europeJob {
+		t.Errorf("Expected the unchanged job to keep polling")
+	}
+	select {
+	case <-europeJob.stop:
+		t.Errorf("Expected the poll of the unchanged job not to be stopped")
+	default:
+	}
+	if e.jobs[1].last != nil {
+		t.Errorf("Expected no snapshot for the new job")
+	}
+	if e.last != snap {
+		t.Errorf("Expected the merged snapshot to be kept")
+	}
+	if e.lifecycle.prev != nil {
+		t.Errorf("Expected the lifecycle baseline to be reset for the new job")
+	}
+	e.mu.RUnlock()
+
+	loadErr = errors.New("invalid config file")
+	if err := r.reload(); err == nil {
+		t.Errorf("Expected reload error")
+	}
+	if v := metricValue(reloadSuccess); v != 0 {
+		t.Errorf("Expected failed reload, got %v", v)
+	}
+	e.mu.RLock()
+	if len(e.jobs) != 2 {
+		t.Errorf("Expected the jobs to be kept, got %d", len(e.jobs))
+	}
+	e.mu.RUnlock()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/-/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", rec.Code)
+	}
+	loadErr = nil
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/-/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resolutionTime is the duration of closed events from start to end
+var resolutionTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:      "event_resolution_seconds",
+	Namespace: Namespace,
+	Help:      "Duration of closed aws health events from start to end time",
+	Buckets: []float64{
+		(5 * time.Minute).Seconds(),
+		(15 * time.Minute).Seconds(),
+		(30 * time.Minute).Seconds(),
+		time.Hour.Seconds(),
+		(2 * time.Hour).Seconds(),
+		(4 * time.Hour).Seconds(),
+		(8 * time.Hour).Seconds(),
+		(24 * time.Hour).Seconds(),
+		(3 * 24 * time.Hour).Seconds(),
+		(7 * 24 * time.Hour).Seconds(),
+	},
+}, lifecycleLabels)
+
+func init() {
+	prometheus.MustRegister(resolutionTime)
+}
+
+// resolutionSummary are the resolution times of the closed events of a
+// service, region and category on /api/v1/resolution-times
+type resolutionSummary struct {
+	Service       string  `json:"service"`
+	Region        string  `json:"region"`
+	Category      string  `json:"category"`
+	Count         int     `json:"count"`
+	MeanSeconds   float64 `json:"meanSeconds"`
+	MedianSeconds float64 `json:"medianSeconds"`
+	P90Seconds    float64 `json:"p90Seconds"`
+	MaxSeconds    float64 `json:"maxSeconds"`
+
+	durations []float64
+}
+
+// observeResolution adds the duration of the closed event e to the histogram
+func observeResolution(e *health.Event) {
+	if d, ok := resolution(e); ok {
+		resolutionTime.WithLabelValues(aws.StringValue(e.EventTypeCategory), aws.StringValue(e.Region), aws.StringValue(e.Service)).Observe(d)
+	}
+}
+
+// resolution returns the seconds from start to end of e, false if e has no
+// start or end time
+func resolution(e *health.Event) (float64, bool) {
+	if e.StartTime == nil || e.EndTime == nil || e.EndTime.Before(*e.StartTime) {
+		return 0, false
+	}
+	return e.EndTime.Sub(*e.StartTime).Seconds(), true
+}
+
+// resolutionTimes serves /api/v1/resolution-times. Unlike the histogram,
+// which starts with the exporter, the summary covers all closed events of
+// the last poll. In aggregate mode closed events are not listed, only the
+// events that closed while the exporter was running are covered. It can be
+// filtered with the service, region and category query parameters.
+func (a *eventsAPI) resolutionTimes(w http.ResponseWriter, r *http.Request) {
+	snap := a.exporter.snapshot()
+	if snap == nil {
+		writeError(w, http.StatusServiceUnavailable, "no successful poll of the AWS Health API yet")
+		return
+	}
+
+	q := r.URL.Query()
+	groups := map[[3]string]*resolutionSummary{}
+	events := append(snap.events[:len(snap.events):len(snap.events)], a.exporter.lifecycle.closedEvents()...)
+	for _, e := range events {
+		if aws.StringValue(e.StatusCode) != health.EventStatusCodeClosed ||
+			!matchesQuery(q["service"], e.Service) ||
+			!matchesQuery(q["region"], e.Region) ||
+			!matchesQuery(q["category"], e.EventTypeCategory) {
+			continue
+		}
+		d, ok := resolution(e)
+		if !ok {
+			continue
+		}
+
+		key := [3]string{aws.StringValue(e.Service), aws.StringValue(e.Region), aws.StringValue(e.EventTypeCategory)}
+		s, ok := groups[key]
+		if !ok {
+			s = &resolutionSummary{Service: key[0], Region: key[1], Category: key[2]}
+			groups[key] = s
+		}
+		s.durations = append(s.durations, d)
+	}
+
+	summaries := []*resolutionSummary{}
+	for _, s := range groups {
+		s.summarize()
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Category < b.Category
+	})
+
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *resolutionSummary) summarize() {
+	sort.Float64s(s.durations)
+	var sum float64
+	for _, d := range s.durations {
+		sum += d
+	}
+	s.Count = len(s.durations)
+	s.MeanSeconds = sum / float64(s.Count)
+	s.MedianSeconds = percentile(s.durations, 0.5)
+	s.P90Seconds = percentile(s.durations, 0.9)
+	s.MaxSeconds = s.durations[s.Count-1]
+}
+
+// percentile returns the nearest-rank percentile p of the sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
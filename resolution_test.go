@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestResolutionTimes(t *testing.T) {
+	start := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	event := func(service, status string, d time.Duration) *health.Event {
+		e := &health.Event{
+			EventTypeCategory: aws.String("issue"),
+			Region:            aws.String("us-east-1"),
+			Service:           aws.String(service),
+			StatusCode:        aws.String(status),
+			StartTime:         aws.Time(start),
+		}
+		if d > 0 {
+			e.EndTime = aws.Time(start.Add(d))
+		}
+		return e
+	}
+
+	a := &eventsAPI{exporter: &exporter{last: &snapshot{events: []*health.Event{
+		event("EC2", "closed", time.Hour),
+		event("EC2", "closed", 3*time.Hour),
+		event("EC2", "closed", 2*time.Hour),
+		event("EC2", "closed", 0),
+		event("EC2", "open", 0),
+		event("LAMBDA", "closed", time.Minute),
+	}}}}
+
+	rec := httptest.NewRecorder()
+	a.resolutionTimes(rec, httptest.NewRequest("GET", "/api/v1/resolution-times?service=ec2", nil))
+
+	var got []resolutionSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Expected 1 summary, got %v", got)
+	}
+	expected := resolutionSummary{Service: "EC2", Region: "us-east-1", Category: "issue", Count: 3, MeanSeconds: 7200, MedianSeconds: 7200, P90Seconds: 10800, MaxSeconds: 10800}
+	if got[0].Service != expected.Service || got[0].Count != expected.Count || got[0].MeanSeconds != expected.MeanSeconds ||
+		got[0].MedianSeconds != expected.MedianSeconds || got[0].P90Seconds != expected.P90Seconds || got[0].MaxSeconds != expected.MaxSeconds {
+		t.Errorf("Expected %+v, got %+v", expected, got[0])
+	}
+}
+
+func TestObserveResolution(t *testing.T) {
+	start := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	l := &lifecycle{}
+	open := &health.Event{Arn: aws.String(testARN), EventTypeCategory: aws.String("issue"), Region: aws.String("eu-west-1"), Service: aws.String("RESOLUTION"), StatusCode: aws.String("open"), StartTime: aws.Time(start)}
+	closed := *open
+	closed.StatusCode = aws.String("closed")
+	closed.EndTime = aws.Time(start.Add(90 * time.Minute))
+	closed.LastUpdatedTime = closed.EndTime
+
+	l.observe(&snapshot{events: []*health.Event{open}})
+	l.observe(&snapshot{events: []*health.Event{&closed}})
+
+	h := resolutionTime.WithLabelValues("issue", "eu-west-1", "RESOLUTION").(prometheus.Histogram)
+	pb := &dto.Metric{}
+	h.Write(pb)
+	if pb.Histogram.GetSampleCount() != 1 || pb.Histogram.GetSampleSum() != 5400 {
+		t.Errorf("Expected a single observation of 5400s, got %v", pb.Histogram)
+	}
+}
+
+func TestObserveResolutionDisappeared(t *testing.T) {
+	start := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	open := &health.Event{Arn: aws.String(testARN), EventTypeCategory: aws.String("issue"), Region: aws.String("eu-west-1"), Service: aws.String("DISAPPEARED"), StatusCode: aws.String("open"), StartTime: aws.Time(start)}
+
+	// in aggregate mode the event ends when it is no longer listed
+	e := &exporter{lifecycle: lifecycle{aggregate: true}}
+	e.lifecycle.observe(&snapshot{events: []*health.Event{open}, timestamp: start.Add(time.Hour)})
+	e.lifecycle.observe(&snapshot{timestamp: start.Add(2 * time.Hour)})
+	e.last = &snapshot{}
+
+	h := resolutionTime.WithLabelValues("issue", "eu-west-1", "DISAPPEARED").(prometheus.Histogram)
+	pb := &dto.Metric{}
+	h.Write(pb)
+	if pb.Histogram.GetSampleCount() != 1 || pb.Histogram.GetSampleSum() != 7200 {
+		t.Errorf("Expected a single observation of 7200s, got %v", pb.Histogram)
+	}
+
+	rec := httptest.NewRecorder()
+	(&eventsAPI{exporter: e}).resolutionTimes(rec, httptest.NewRequest("GET", "/api/v1/resolution-times", nil))
+	var got []resolutionSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Count != 1 || got[0].MaxSeconds != 7200 {
+		t.Errorf("Expected the disappeared event in the summary, got %+v", got)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// slackPostMessageURL is the Slack Web API method used with a bot token
+	slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+	// slackDescriptionLength is the maximum length of the description excerpt
+	slackDescriptionLength = 500
+)
+
+// slack posts transitions as Block Kit messages. With an incoming webhook
+// URL every transition is a new message. With a bot token and channel the
+// messages are sent with chat.postMessage and updates and the closing of an
+// event are threaded under the message of the new event, incoming webhooks
+// don't return the message timestamp required for this.
+type slack struct {
+	url     string
+	token   string
+	channel string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+
+	mu sync.Mutex
+	// threads maps event ARNs to the timestamp of their first message
+	threads map[string]string
+}
+
+// slackMessage is the body of an incoming webhook or chat.postMessage request
+type slackMessage struct {
+	Channel  string       `json:"channel,omitempty"`
+	ThreadTS string       `json:"thread_ts,omitempty"`
+	Text     string       `json:"text"`
+	Blocks   []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string       `json:"type"`
+	Text     *slackText   `json:"text,omitempty"`
+	Fields   []*slackText `json:"fields,omitempty"`
+	Elements []*slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackResponse is the response of chat.postMessage
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+	TS    string `json:"ts"`
+}
+
+func newSlackWebhook(url string, timeout time.Duration, retries int) *slack {
+	return &slack{
+		url:     url,
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		backoff: time.Second,
+		threads: map[string]string{},
+	}
+}
+
+func newSlackBot(token, channel string, timeout time.Duration, retries int) *slack {
+	s := newSlackWebhook(slackPostMessageURL, timeout, retries)
+	s.token = token
+	s.channel = channel
+	return s
+}
+
+func (s *slack) name() string {
+	return "slack"
+}
+
+func (s *slack) send(ctx context.Context, t *transition) error {
+	msg := slackFormat(t)
+	if s.token == "" {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = postWithRetry(ctx, s.client, s.url, body, s.retries, s.backoff, nil)
+		return err
+	}
+
+	s.mu.Lock()
+	msg.Channel = s.channel
+	msg.ThreadTS = s.threads[t.Event.Arn]
+	s.mu.Unlock()
+
+	ts, err := s.postMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case t.Type == transitionClosed:
+		delete(s.threads, t.Event.Arn)
+	case msg.ThreadTS == "":
+		s.threads[t.Event.Arn] = ts
+	}
+	return nil
+}
+
+// inherit takes over the threads of a Slack bot posting to the same channel
+func (s *slack) inherit(old output) {
+	prev, ok := old.(*slack)
+	if !ok || prev == s || s.token == "" || prev.token == "" || prev.channel != s.channel {
+		return
+	}
+
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for arn, ts := range prev.threads {
+		if _, ok := s.threads[arn]; !ok {
+			s.threads[arn] = ts
+		}
+	}
+}
+
+// postMessage sends msg with chat.postMessage and returns its timestamp
+func (s *slack) postMessage(ctx context.Context, msg *slackMessage) (string, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	prepare := func(req *http.Request, body []byte) {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	respBody, err := postWithRetry(ctx, s.client, s.url, body, s.retries, s.backoff, prepare)
+	if err != nil {
+		return "", err
+	}
+
+	// chat.postMessage reports errors with status 200
+	var resp slackResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return "", err
+	}
+	if !resp.OK {
+		return "", fmt.Errorf("chat.postMessage failed: %s", resp.Error)
+	}
+	return resp.TS, nil
+}
+
+// slackFormat renders a transition as Block Kit message
+func slackFormat(t *transition) *slackMessage {
+	e := t.Event
+	title := fmt.Sprintf("[%s] %s %s in %s", strings.ToUpper(t.Type), e.Service, e.EventTypeCategory, orGlobal(e.Region))
+
+	fields := []*slackText{
+		{Type: "mrkdwn", Text: "*Service*\n" + e.Service},
+		{Type: "mrkdwn", Text: "*Region*\n" + orGlobal(e.Region)},
+		{Type: "mrkdwn", Text: "*Category*\n" + e.EventTypeCategory},
+		{Type: "mrkdwn", Text: "*Status*\n" + e.StatusCode},
+		{Type: "mrkdwn", Text: "*Event type*\n" + e.EventTypeCode},
+		{Type: "mrkdwn", Text: "*Start time*\n" + formatTime(e.StartTime)},
+	}
+
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+		{Type: "section", Fields: fields},
+	}
+	if e.Description != "" {
+		blocks = append(blocks, slackBlock{Type: "section", Text: &slackText{Type: "plain_text", Text: excerpt(e.Description, slackDescriptionLength)}})
+	}
+	blocks = append(blocks, slackBlock{Type: "context", Elements: []*slackText{
+		{Type: "mrkdwn", Text: fmt.Sprintf("<%s|Open in the AWS Health Dashboard>", consoleURL(e.Arn))},
+	}})
+
+	return &slackMessage{Text: title, Blocks: blocks}
+}
+
+// orGlobal returns region or "global" for events without a region
+func orGlobal(region string) string {
+	if region == "" {
+		return "global"
+	}
+	return region
+}
+
+// excerpt shortens s to at most n runes
+func excerpt(s string, n int) string {
+	r := []rune(strings.TrimSpace(s))
+	if len(r) <= n {
+		return string(r)
+	}
+	return string(r[:n-1]) + "…"
+}
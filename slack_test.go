@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackFormat(t *testing.T) {
+	msg := slackFormat(&transition{Type: transitionNew, Event: apiEvent{
+		Arn:               testARN,
+		Service:           "EC2",
+		EventTypeCategory: "issue",
+		StatusCode:        "open",
+		Description:       strings.Repeat("x", 1000),
+	}})
+
+	if msg.Text != "[NEW] EC2 issue in global" {
+		t.Errorf("Unexpected text %q", msg.Text)
+	}
+	if len(msg.Blocks) != 4 {
+		t.Fatalf("Expected 4 blocks, got %d", len(msg.Blocks))
+	}
+	if n := len([]rune(msg.Blocks[2].Text.Text)); n != slackDescriptionLength {
+		t.Errorf("Expected description excerpt of %d runes, got %d", slackDescriptionLength, n)
+	}
+	if link := msg.Blocks[3].Elements[0].Text; !strings.Contains(link, consoleURL(testARN)) {
+		t.Errorf("Expected console link, got %q", link)
+	}
+}
+
+func TestSlackBotThreads(t *testing.T) {
+	var got []slackMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer xoxb-token" {
+			t.Errorf("Unexpected authorization %q", auth)
+		}
+		var msg slackMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+		got = append(got, msg)
+		fmt.Fprintf(w, `{"ok": true, "ts": "%d.000"}`, len(got))
+	}))
+	defer srv.Close()
+
+	s := newSlackBot("xoxb-token", "#aws", time.Second, 0)
+	s.url = srv.URL
+
+	for _, typ := range []string{transitionNew, transitionUpdated, transitionClosed, transitionNew} {
+		if err := s.send(context.Background(), &transition{Type: typ, Event: apiEvent{Arn: testARN}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, ts := range []string{"", "1.000", "1.000", ""} {
+		if got[i].Channel != "#aws" {
+			t.Errorf("Unexpected channel %q", got[i].Channel)
+		}
+		if got[i].ThreadTS != ts {
+			t.Errorf("Expected thread_ts %q for message %d, got %q", ts, i, got[i].ThreadTS)
+		}
+	}
+}
+
+func TestSlackBotError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": false, "error": "channel_not_found"}`))
+	}))
+	defer srv.Close()
+
+	s := newSlackBot("xoxb-token", "#missing", time.Second, 0)
+	s.url = srv.URL
+
+	err := s.send(context.Background(), &transition{Type: transitionNew, Event: apiEvent{Arn: testARN}})
+	if err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("Expected channel_not_found error, got %v", err)
+	}
+	if len(s.threads) != 0 {
+		t.Errorf("Expected no thread, got %v", s.threads)
+	}
+}
+
+func TestSlackInherit(t *testing.T) {
+	old := newSlackBot("xoxb-token", "#aws", time.Second, 0)
+	old.threads[testARN] = "1.000"
+	n := newNotifier(&eventsAPI{exporter: &exporter{}}, []output{old})
+
+	// a reloaded bot token keeps the threads of the channel
+	bot := newSlackBot("xoxb-rotated", "#aws", time.Second, 0)
+	other := newSlackBot("xoxb-rotated", "#other", time.Second, 0)
+	n.setOutputs([]output{bot, other})
+	if ts := bot.threads[testARN]; ts != "1.000" {
+		t.Errorf("Expected the thread to be kept, got %q", ts)
+	}
+	if len(other.threads) != 0 {
+		t.Errorf("Expected no threads for another channel, got %v", other.threads)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// eventsBucket holds a storedEvent per event ARN
+	eventsBucket = []byte("events")
+
+	// errStoreClosed is returned by compact if the store could not be
+	// reopened, every later call would fail
+	errStoreClosed = errors.New("failed to reopen the store")
+)
+
+// store persists every observed version of the events in a single bbolt
+// file, so the history of an event survives restarts of the exporter.
+type store struct {
+	path string
+	// retention is how long events that are no longer reported by the
+	// AWS Health API are kept
+	retention time.Duration
+
+	// mu guards db, which is replaced by compact, and active
+	mu sync.Mutex
+	db *bolt.DB
+	// active are the ARNs of the events whose latest version is open or
+	// upcoming
+	active map[string]bool
+}
+
+// storedEvent is the history of an event, oldest first
+type storedEvent struct {
+	Versions []*eventVersion `json:"versions"`
+	Statuses []*statusChange `json:"statuses"`
+}
+
+// eventVersion is an observed version of an event. A new version is
+// recorded whenever the last updated time or the status changes.
+type eventVersion struct {
+	Observed time.Time     `json:"observed"`
+	Event    *health.Event `json:"event"`
+}
+
+type statusChange struct {
+	Time       time.Time `json:"time"`
+	StatusCode string    `json:"statusCode"`
+}
+
+func openStore(path string, retention time.Duration) (*store, error) {
+	db, err := openBolt(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &store{path: path, retention: retention, db: db}
+	latest, err := s.latest()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.active = map[string]bool{}
+	for arn, e := range latest {
+		if isActive(e) {
+			s.active[arn] = true
+		}
+	}
+	return s, nil
+}
+
+func openBolt(path string) (*bolt.DB, error) {
+	// the timeout fails the start if another exporter holds the file
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (s *store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+// record adds a version for every event of snap that changed since it was
+// last recorded. Open and upcoming events that are no longer part of snap
+// are recorded as closed like the lifecycle and the notifications report
+// them, otherwise they would be reported as closed again after a restart.
+func (s *store) record(snap *snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := map[string]bool{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+		current := map[string]bool{}
+		for _, e := range snap.events {
+			arn := aws.StringValue(e.Arn)
+			current[arn] = true
+			if isActive(e) {
+				active[arn] = true
+			}
+			if err := addVersion(b, arn, snap.timestamp, func(*health.Event) *health.Event { return e }); err != nil {
+				return err
+			}
+		}
+		for arn := range s.active {
+			if current[arn] {
+				continue
+			}
+			err := addVersion(b, arn, snap.timestamp, func(latest *health.Event) *health.Event {
+				if latest == nil || !isActive(latest) {
+					return nil
+				}
+				return disappeared(latest, snap.timestamp)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.active = active
+	return nil
+}
+
+// addVersion adds the event returned by version for the latest stored
+// version of the event with the given ARN, nothing if version returns nil
+func addVersion(b *bolt.Bucket, arn string, observed time.Time, version func(latest *health.Event) *health.Event) error {
+	key := []byte(arn)
+	stored := &storedEvent{}
+	if v := b.Get(key); v != nil {
+		if err := json.Unmarshal(v, stored); err != nil {
+			return err
+		}
+	}
+	e := version(stored.latest())
+	if e == nil || !stored.add(e, observed) {
+		return nil
+	}
+
+	v, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, v)
+}
+
+// add appends e as new version if it differs from the latest one
+func (h *storedEvent) add(e *health.Event, observed time.Time) bool {
+	status := aws.StringValue(e.StatusCode)
+	if latest := h.latest(); latest != nil {
+		if aws.TimeValue(latest.LastUpdatedTime).Equal(aws.TimeValue(e.LastUpdatedTime)) &&
+			aws.StringValue(latest.StatusCode) == status {
+			return false
+		}
+	}
+
+	h.Versions = append(h.Versions, &eventVersion{Observed: observed, Event: e})
+	if n := len(h.Statuses); n == 0 || h.Statuses[n-1].StatusCode != status {
+		h.Statuses = append(h.Statuses, &statusChange{Time: observed, StatusCode: status})
+	}
+	return true
+}
+
+// latest returns the latest version of the event or nil
+func (h *storedEvent) latest() *health.Event {
+	if len(h.Versions) == 0 {
+		return nil
+	}
+	return h.Versions[len(h.Versions)-1].Event
+}
+
+// history returns the stored history of the event with the given ARN or nil
+func (s *store) history(arn string) (*storedEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stored *storedEvent
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(eventsBucket).Get([]byte(arn))
+		if v == nil {
+			return nil
+		}
+		stored = &storedEvent{}
+		return json.Unmarshal(v, stored)
+	})
+	return stored, err
+}
+
+// latest returns the latest version of every stored event by ARN or nil
+// if the store is empty
+func (s *store) latest() (map[string]*health.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events map[string]*health.Event
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(eventsBucket).ForEach(func(k, v []byte) error {
+			stored := &storedEvent{}
+			if err := json.Unmarshal(v, stored); err != nil {
+				return err
+			}
+			if events == nil {
+				events = map[string]*health.Event{}
+			}
+			events[string(k)] = stored.latest()
+			return nil
+		})
+	})
+	return events, err
+}
+
+// run expires and compacts the store every interval until ctx is done
+func (s *store) run(ctx context.Context, e *exporter, interval time.Duration) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+
+		snap := e.snapshot()
+		if snap == nil {
+			continue
+		}
+		n, err := s.expire(snap, time.Now().Add(-s.retention))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		if err := s.compact(); err != nil {
+			if errors.Is(err, errStoreClosed) {
+				log.Fatal(err)
+			}
+			log.Println(err)
+		}
+	}
+}
+
+// expire deletes the events that are not part of snap anymore and were
+// last changed before cutoff. It returns the number of deleted events.
+func (s *store) expire(snap *snapshot, cutoff time.Time) (int, error) {
+	current := map[string]bool{}
+	for _, e := range snap.events {
+		current[aws.StringValue(e.Arn)] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if current[string(k)] {
+				return nil
+			}
+			stored := &storedEvent{}
+			if err := json.Unmarshal(v, stored); err != nil {
+				return err
+			}
+			if last := stored.Versions[len(stored.Versions)-1]; last.Observed.Before(cutoff) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// keys must not be deleted while iterating with ForEach
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(expired)
+		return nil
+	})
+	return n, err
+}
+
+// compact rewrites the store into a new file. bbolt reuses the pages of
+// deleted events but never shrinks the file on its own.
+func (s *store) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp := s.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = s.db.View(func(src *bolt.Tx) error {
+		return dst.Update(func(tx *bolt.Tx) error {
+			return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+				copied, err := tx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return b.ForEach(func(k, v []byte) error {
+					return copied.Put(k, v)
+				})
+			})
+		})
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := s.db.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// the original file is reopened if the rename fails
+	renameErr := os.Rename(tmp, s.path)
+	if renameErr != nil {
+		os.Remove(tmp)
+	}
+	db, err := openBolt(s.path)
+	if err != nil {
+		return fmt.Errorf("%w %s: %v", errStoreClosed, s.path, err)
+	}
+	s.db = db
+	return renameErr
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/health"
+)
+
+func TestStoreRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	s, err := openStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := time.Unix(1700000000, 0)
+	event := func(status string, lastUpdated time.Time) *health.Event {
+		return &health.Event{Arn: aws.String(testARN), StatusCode: aws.String(status), LastUpdatedTime: aws.Time(lastUpdated)}
+	}
+	for i, e := range []*health.Event{
+		event("open", updated),
+		event("open", updated),
+		event("open", updated.Add(time.Minute)),
+		event("closed", updated.Add(2*time.Minute)),
+	} {
+		if err := s.record(&snapshot{events: []*health.Event{e}, timestamp: updated.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the history survives a restart
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = openStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	h, err := s.history(testARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Versions) != 3 {
+		t.Errorf("Expected 3 versions, got %d", len(h.Versions))
+	}
+	if len(h.Statuses) != 2 || h.Statuses[1].StatusCode != "closed" || !h.Statuses[1].Time.Equal(updated.Add(3*time.Minute)) {
+		t.Errorf("Unexpected status history %v", h.Statuses)
+	}
+
+	latest, err := s.latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(latest[testARN].StatusCode) != "closed" {
+		t.Errorf("Expected latest version to be closed, got %v", latest[testARN])
+	}
+}
+
+func TestStoreRecordDisappeared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	s, err := openStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	observed := time.Unix(1700000000, 0)
+	open := &health.Event{Arn: aws.String(testARN), StatusCode: aws.String("open")}
+	if err := s.record(&snapshot{events: []*health.Event{open}, timestamp: observed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event disappears after a restart, e.g. because it closed in
+	// aggregate mode, and is only recorded as closed once
+	for i := 1; i <= 2; i++ {
+		s, err = openStore(path, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.record(&snapshot{timestamp: observed.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err = openStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	h, err := s.history(testARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(h.Versions))
+	}
+	closed := h.Versions[1].Event
+	if aws.StringValue(closed.StatusCode) != "closed" || !aws.TimeValue(closed.EndTime).Equal(observed.Add(time.Minute)) {
+		t.Errorf("Expected the event to be closed when it disappeared, got %v", closed)
+	}
+}
+
+func TestStoreLatestEmpty(t *testing.T) {
+	s, err := openStore(filepath.Join(t.TempDir(), "events.db"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// an empty store must not become the baseline of the notifier
+	if latest, err := s.latest(); err != nil || latest != nil {
+		t.Errorf("Expected nil, got %v, %v", latest, err)
+	}
+}
+
+func TestStoreExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	s, err := openStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	old := &health.Event{Arn: aws.String("arn:event/old"), StatusCode: aws.String("closed")}
+	current := &health.Event{Arn: aws.String("arn:event/current"), StatusCode: aws.String("open")}
+	recent := &health.Event{Arn: aws.String("arn:event/recent"), StatusCode: aws.String("closed")}
+	s.record(&snapshot{events: []*health.Event{old, current}, timestamp: now.Add(-2 * time.Hour)})
+	s.record(&snapshot{events: []*health.Event{recent}, timestamp: now})
+
+	n, err := s.expire(&snapshot{events: []*health.Event{current}}, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 expired event, got %d", n)
+	}
+
+	if err := s.compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("Expected temporary file to be removed, got %v", err)
+	}
+	latest, err := s.latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 2 || latest["arn:event/old"] != nil {
+		t.Errorf("Unexpected events after compaction %v", latest)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// maxVerifiedCache is the number of successful password and token checks
+// that are cached, bcrypt is slow on purpose
+const maxVerifiedCache = 100
+
+// webConfig is the file given with --web.config.file. It is a superset of
+// the web configuration of the Prometheus exporter-toolkit.
+type webConfig struct {
+	TLSConfig  *tlsServerConfig `yaml:"tls_server_config"`
+	HTTPConfig httpServerConfig `yaml:"http_server_config"`
+	// Users maps user names to bcrypt hashes of their passwords
+	Users map[string]string `yaml:"basic_auth_users"`
+	// BearerTokens are bcrypt hashes of the accepted tokens, they are not
+	// part of the exporter-toolkit format
+	BearerTokens []string `yaml:"bearer_tokens"`
+}
+
+type tlsServerConfig struct {
+	CertFile          string   `yaml:"cert_file"`
+	KeyFile           string   `yaml:"key_file"`
+	ClientAuthType    string   `yaml:"client_auth_type"`
+	ClientCAFile      string   `yaml:"client_ca_file"`
+	ClientAllowedSANs []string `yaml:"client_allowed_sans"`
+	MinVersion        string   `yaml:"min_version"`
+	MaxVersion        string   `yaml:"max_version"`
+	CipherSuites      []string `yaml:"cipher_suites"`
+	CurvePreferences  []string `yaml:"curve_preferences"`
+	// PreferServerCipherSuites is only accepted for compatibility, Go
+	// ignores it since 1.18
+	PreferServerCipherSuites bool `yaml:"prefer_server_cipher_suites"`
+}
+
+type httpServerConfig struct {
+	// HTTP2 defaults to true
+	HTTP2   *bool             `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+var (
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"":                           tls.NoClientCert,
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+	tlsVersions = map[string]uint16{
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+	curves = map[string]tls.CurveID{
+		"CurveP256": tls.CurveP256,
+		"CurveP384": tls.CurveP384,
+		"CurveP521": tls.CurveP521,
+		"X25519":    tls.X25519,
+	}
+)
+
+// loadWebConfig reads and validates the YAML file at path
+func loadWebConfig(path string) (*webConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &webConfig{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("invalid web config file %s: %v", path, err)
+	}
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid web config file %s: password of user %s: %v", path, user, err)
+		}
+	}
+	for i, hash := range c.BearerTokens {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid web config file %s: bearer token %d: %v", path, i+1, err)
+		}
+	}
+	if c.TLSConfig != nil {
+		if _, err := c.TLSConfig.config(); err != nil {
+			return nil, fmt.Errorf("invalid web config file %s: %v", path, err)
+		}
+	}
+	return c, nil
+}
+
+// config returns the TLS config of the server, the certificate is reloaded
+// whenever the files change
+func (c *tlsServerConfig) config() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file are required")
+	}
+	certs := &certReloader{certFile: c.CertFile, keyFile: c.KeyFile}
+	if _, err := certs.get(nil); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.get,
+	}
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown min_version %s", c.MinVersion)
+		}
+		cfg.MinVersion = v
+	}
+	if c.MaxVersion != "" {
+		v, ok := tlsVersions[c.MaxVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown max_version %s", c.MaxVersion)
+		}
+		cfg.MaxVersion = v
+	}
+	for _, name := range c.CipherSuites {
+		id, ok := cipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	for _, name := range c.CurvePreferences {
+		id, ok := curves[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %s", name)
+		}
+		cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+	}
+
+	auth, ok := clientAuthTypes[c.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("unknown client_auth_type %s", c.ClientAuthType)
+	}
+	cfg.ClientAuth = auth
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in client_ca_file %s", c.ClientCAFile)
+		}
+	} else if auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_ca_file is required with client_auth_type %s", c.ClientAuthType)
+	}
+	if len(c.ClientAllowedSANs) > 0 {
+		// other client auth types don't verify the certificate, any
+		// self-signed certificate could claim an allowed SAN
+		if auth != tls.VerifyClientCertIfGiven && auth != tls.RequireAndVerifyClientCert {
+			return nil, errors.New("client_allowed_sans requires client_auth_type VerifyClientCertIfGiven or RequireAndVerifyClientCert")
+		}
+		cfg.VerifyPeerCertificate = verifySANs(c.ClientAllowedSANs)
+	}
+	return cfg, nil
+}
+
+func cipherSuite(name string) (uint16, bool) {
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if s.Name == name {
+			return s.ID, true
+		}
+	}
+	return 0, false
+}
+
+// verifySANs only accepts verified client certificates with one of the
+// allowed subject alternative names, connections without certificate are
+// left to the client auth type
+func verifySANs(allowed []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, chains [][]*x509.Certificate) error {
+		if len(raw) == 0 {
+			return nil
+		}
+		if len(chains) == 0 {
+			return errors.New("client certificate was not verified")
+		}
+		cert := chains[0][0]
+		var sans []string
+		sans = append(sans, cert.DNSNames...)
+		sans = append(sans, cert.EmailAddresses...)
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+		for _, san := range sans {
+			for _, a := range allowed {
+				if san == a {
+					return nil
+				}
+			}
+		}
+		return errors.New("client certificate has no allowed subject alternative name")
+	}
+}
+
+// certReloader loads the certificate again whenever the certificate or
+// key file changed, e.g. after a renewal
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+func (c *certReloader) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cert, err := c.load()
+	if err != nil && c.cert != nil {
+		// keep the previous certificate while the files are replaced
+		return c.cert, nil
+	}
+	return cert, err
+}
+
+// load returns the certificate, it is only read again if a file changed.
+// It must be called with mu held.
+func (c *certReloader) load() (*tls.Certificate, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = fi.ModTime()
+	}
+	if c.cert != nil && modTimes == c.modTimes {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	c.cert, c.modTimes = &cert, modTimes
+	return c.cert, nil
+}
+
+// webAuth sets the configured headers and requires basic auth or a bearer
+// token on every route if any credentials are configured
+type webAuth struct {
+	users   map[string]string
+	tokens  []string
+	headers map[string]string
+
+	mu sync.Mutex
+	// verified caches the successful checks by a hash of the hashed and
+	// the given secret
+	verified map[string]bool
+}
+
+func newWebAuth(c *webConfig) *webAuth {
+	return &webAuth{
+		users:    c.Users,
+		tokens:   c.BearerTokens,
+		headers:  c.HTTPConfig.Headers,
+		verified: map[string]bool{},
+	}
+}
+
+func (a *webAuth) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range a.headers {
+			w.Header().Set(k, v)
+		}
+		if len(a.users) == 0 && len(a.tokens) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if a.authorized(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if len(a.users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="aws-health-exporter"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// authorized checks the basic auth credentials or the bearer token of r
+func (a *webAuth) authorized(r *http.Request) bool {
+	if user, password, ok := r.BasicAuth(); ok {
+		hash, known := a.users[user]
+		if !known {
+			// compare anyway to not reveal the existence of users
+			hash = dummyHash
+		}
+		return a.verify(hash, password) && known
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	for _, hash := range a.tokens {
+		if a.verify(hash, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// dummyHash is compared for unknown users, it is the bcrypt hash of "dummy"
+const dummyHash = "$2a$10$1lvlAhSDh4FXrnM.j2IX1O8RjA8y39lmMWxs0nr96VpXGhY2obxJO"
+
+func (a *webAuth) verify(hash, secret string) bool {
+	sum := sha256.Sum256([]byte(hash + "\x00" + secret))
+	key := hex.EncodeToString(sum[:])
+
+	a.mu.Lock()
+	ok := a.verified[key]
+	a.mu.Unlock()
+	if ok {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) != nil {
+		return false
+	}
+	a.mu.Lock()
+	if len(a.verified) >= maxVerifiedCache {
+		a.verified = map[string]bool{}
+	}
+	a.verified[key] = true
+	a.mu.Unlock()
+	return true
+}
+
+// apply configures server with TLS, the headers and the authentication
+func (c *webConfig) apply(server *http.Server) error {
+	server.Handler = newWebAuth(c).handler(server.Handler)
+	if c.TLSConfig == nil {
+		return nil
+	}
+
+	cfg, err := c.TLSConfig.config()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = cfg
+	if c.HTTPConfig.HTTP2 != nil && !*c.HTTPConfig.HTTP2 {
+		// a non-nil empty map disables HTTP/2
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func hash(t *testing.T, secret string) string {
+	h, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+// writeCert writes a certificate for name signed by parent, self-signed if
+// parent is nil, and its key to dir
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "localhost", nil, nil)
+	tlsConfig := fmt.Sprintf("tls_server_config:\n  cert_file: %s\n  key_file: %s\n", filepath.Join(dir, "localhost.crt"), filepath.Join(dir, "localhost.key"))
+
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{tlsConfig, ""},
+		{"basic_auth_users:\n  admin: " + hash(t, "secret") + "\nbearer_tokens: [" + hash(t, "token") + "]\n", ""},
+		{"basic_auth_users:\n  admin: secret\n", "password of user admin"},
+		{"bearer_tokens: [token]\n", "bearer token 1"},
+		{tlsConfig + "  client_auth_type: RequireAndVerifyClientCert\n", "client_ca_file is required"},
+		{tlsConfig + "  client_auth_type: Always\n", "unknown client_auth_type"},
+		{tlsConfig + "  client_auth_type: RequireAnyClientCert\n  client_ca_file: " + filepath.Join(dir, "localhost.crt") + "\n  client_allowed_sans: [prometheus]\n", "client_allowed_sans requires client_auth_type"},
+		{tlsConfig + "  min_version: TLS14\n", "unknown min_version"},
+		{tlsConfig + "  cipher_suites: [TLS_NULL]\n", "unknown cipher suite"},
+		{"tls_server_config:\n  cert_file: " + filepath.Join(dir, "localhost.crt") + "\n", "key_file are required"},
+		{"tls_config: {}\n", "field tls_config not found"},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, "web.yml")
+		ioutil.WriteFile(path, []byte(test.config), 0600)
+		_, err := loadWebConfig(path)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%d: Unexpected error: %v", i, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%d: Expected error %q, got %v", i, test.err, err)
+		}
+	}
+}
+
+func TestWebAuth(t *testing.T) {
+	a := newWebAuth(&webConfig{
+		Users:        map[string]string{"admin": hash(t, "secret")},
+		BearerTokens: []string{hash(t, "token")},
+		HTTPConfig:   httpServerConfig{Headers: map[string]string{"X-Frame-Options": "deny"}},
+	})
+	h := a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path     string
+		user     string
+		password string
+		token    string
+		expected int
+	}{
+		{"/metrics", "", "", "", http.StatusUnauthorized},
+		{"/metrics", "admin", "secret", "", http.StatusOK},
+		{"/metrics", "admin", "wrong", "", http.StatusUnauthorized},
+		{"/metrics", "unknown", "secret", "", http.StatusUnauthorized},
+		{"/api/v1/events", "", "", "token", http.StatusOK},
+		{"/api/v1/events", "", "", "wrong", http.StatusUnauthorized},
+		{"/", "", "", "token", http.StatusOK},
+		{"/events/arn", "", "", "wrong", http.StatusUnauthorized},
+		{"/", "admin", "secret", "", http.StatusOK},
+	}
+	for i, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.user != "" {
+			req.SetBasicAuth(test.user, test.password)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("%d: Expected status %d, got %d", i, test.expected, rec.Code)
+		}
+		if rec.Header().Get("X-Frame-Options") != "deny" {
+			t.Errorf("%d: Expected the configured headers", i)
+		}
+	}
+
+	// without users every route requires a token, including the dashboard
+	a = newWebAuth(&webConfig{BearerTokens: []string{hash(t, "token")}})
+	h = a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, path := range []string{"/", "/events/arn", "/metrics"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: Expected status 401 without token, got %d", path, rec.Code)
+		}
+		if rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: Expected a bearer challenge, got %q", path, rec.Header().Get("WWW-Authenticate"))
+		}
+
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: Expected status 200 with token, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestWebMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "localhost", ca, caKey)
+	writeCert(t, dir, "prometheus", ca, caKey)
+	writeCert(t, dir, "other", ca, caKey)
+
+	c := &tlsServerConfig{
+		CertFile:          filepath.Join(dir, "localhost.crt"),
+		KeyFile:           filepath.Join(dir, "localhost.key"),
+		ClientAuthType:    "RequireAndVerifyClientCert",
+		ClientCAFile:      filepath.Join(dir, "ca.crt"),
+		ClientAllowedSANs: []string{"prometheus"},
+	}
+	cfg, err := c.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(client string) error {
+		tlsConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if client != "" {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, client+".crt"), filepath.Join(dir, client+".key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := httpClient.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err := get("prometheus"); err != nil {
+		t.Errorf("Expected the allowed client to connect, got %v", err)
+	}
+	if err := get(""); err == nil {
+		t.Errorf("Expected an error without client certificate")
+	}
+	if err := get("other"); err == nil {
+		t.Errorf("Expected an error for a client without allowed SAN")
+	}
+
+	// a certificate that was presented but not verified is rejected
+	verify := verifySANs([]string{"prometheus"})
+	if err := verify([][]byte{ca.Raw}, nil); err == nil {
+		t.Errorf("Expected an error for an unverified client certificate")
+	}
+	if err := verify(nil, nil); err != nil {
+		t.Errorf("Expected connections without certificate to be left to the client auth type, got %v", err)
+	}
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := writeCert(t, dir, "localhost", nil, nil)
+	c := &certReloader{certFile: filepath.Join(dir, "localhost.crt"), keyFile: filepath.Join(dir, "localhost.key")}
+
+	cert, err := c.get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cert.Certificate[0]) != string(first.Raw) {
+		t.Fatalf("Expected the first certificate")
+	}
+
+	renewed, _ := writeCert(t, dir, "localhost", nil, nil)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(c.certFile, later, later)
+	cert, err = c.get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cert.Certificate[0]) != string(renewed.Raw) {
+		t.Errorf("Expected the renewed certificate")
+	}
+
+	// a broken file keeps the previous certificate
+	ioutil.WriteFile(c.keyFile, []byte("broken"), 0600)
+	os.Chtimes(c.keyFile, later.Add(time.Minute), later.Add(time.Minute))
+	if cert, err := c.get(nil); err != nil || string(cert.Certificate[0]) != string(renewed.Raw) {
+		t.Errorf("Expected the renewed certificate to be kept, got %v", err)
+	}
+}
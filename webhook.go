@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// signatureHeader carries the HMAC-SHA256 of the request body if a secret is configured
+const signatureHeader = "X-Signature-256"
+
+// webhook POSTs every transition as JSON to a URL
+type webhook struct {
+	url    string
+	secret []byte
+	client *http.Client
+
+	// retries is the number of additional attempts, backoff the delay
+	// before the first retry which doubles with every attempt
+	retries int
+	backoff time.Duration
+}
+
+func newWebhook(url string, secret []byte, timeout time.Duration, retries int) *webhook {
+	return &webhook{
+		url:     url,
+		secret:  secret,
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		backoff: time.Second,
+	}
+}
+
+func (w *webhook) name() string {
+	return "webhook"
+}
+
+func (w *webhook) send(ctx context.Context, t *transition) error {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = postWithRetry(ctx, w.client, w.url, body, w.retries, w.backoff, w.sign)
+	return err
+}
+
+// sign adds the signature header, the receiver verifies it by computing
+// the HMAC-SHA256 of the body with the shared secret
+func (w *webhook) sign(req *http.Request, body []byte) {
+	if len(w.secret) == 0 {
+		return
+	}
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write(body)
+	req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// postWithRetry POSTs body as JSON to url and returns the response body.
+// Network errors, 429 and 5xx responses are retried with exponential
+// backoff until ctx is done. prepare may modify the request before it is
+// sent, e.g. to sign it.
+func postWithRetry(ctx context.Context, client *http.Client, url string, body []byte, retries int, backoff time.Duration, prepare func(*http.Request, []byte)) ([]byte, error) {
+	var (
+		resp []byte
+		err  error
+	)
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%v, giving up: %v", err, ctx.Err())
+			}
+			backoff *= 2
+		}
+
+		var retry bool
+		resp, retry, err = post(ctx, client, url, body, prepare)
+		if err == nil || !retry {
+			return resp, err
+		}
+	}
+	return nil, err
+}
+
+// post sends a single request and returns the response body and whether a
+// failure should be retried
+func post(ctx context.Context, client *http.Client, url string, body []byte, prepare func(*http.Request, []byte)) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if prepare != nil {
+		prepare(req, body)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, true, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retry, fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+	return respBody, false, nil
+}
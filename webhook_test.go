@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSend(t *testing.T) {
+	secret := []byte("s3cr3t")
+	var attempts int
+	var got transition
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		if sig := r.Header.Get(signatureHeader); sig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("Invalid signature %q", sig)
+		}
+		json.Unmarshal(body, &got)
+	}))
+	defer srv.Close()
+
+	w := newWebhook(srv.URL, secret, time.Second, 1)
+	w.backoff = time.Millisecond
+
+	err := w.send(context.Background(), &transition{Type: transitionClosed, Event: apiEvent{Arn: "arn:event/1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+	if got.Type != transitionClosed || got.Event.Arn != "arn:event/1" {
+		t.Errorf("Invalid payload: %v", got)
+	}
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	w := newWebhook(srv.URL, nil, time.Second, 3)
+	w.backoff = time.Millisecond
+
+	if err := w.send(context.Background(), &transition{Type: transitionNew}); err == nil {
+		t.Errorf("Expected an error")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestWebhookRetryCanceled(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	w := newWebhook(srv.URL, nil, time.Second, 3)
+	w.backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.send(ctx, &transition{Type: transitionNew}); err == nil {
+		t.Errorf("Expected an error")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected the backoff to end with ctx, got %d attempts", attempts)
+	}
+}